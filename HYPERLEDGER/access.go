@@ -0,0 +1,170 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// MSP checks only say which organisation is calling. On top of them the admin
+// can require a role, taken from the "role" attribute of the caller's X.509
+// certificate (issued with e.g. fabric-ca-client register --id.attrs
+// role=minter:ecert), for any transaction function. The policy is checked
+// before every transaction of both contracts; functions it does not list stay
+// open to every user of an authorised org.
+
+// roleAttribute is the certificate attribute holding the caller's role.
+const roleAttribute = "role"
+
+// AccessPolicy maps a transaction function name to the roles allowed to call it.
+type AccessPolicy struct {
+	Roles map[string][]string `json:"roles"`
+}
+
+// unrestricted functions can never be locked by the policy, so the admin
+// cannot shut itself out.
+var unrestricted = map[string]bool{
+	"InitLedger":      true,
+	"SetAccessPolicy": true,
+}
+
+// transactionNames are the functions contractapi exposes on both contracts:
+// their exported methods less those every contractapi.Contract has.
+var transactionNames = func() map[string]bool {
+	names := map[string]bool{}
+	for _, contract := range []interface{}{&SmartContract{}, &GovernanceContract{}} {
+		t := reflect.TypeOf(contract)
+		for i := 0; i < t.NumMethod(); i++ {
+			names[t.Method(i).Name] = true
+		}
+	}
+	base := reflect.TypeOf(&contractapi.Contract{})
+	for i := 0; i < base.NumMethod(); i++ {
+		delete(names, base.Method(i).Name)
+	}
+	return names
+}()
+
+func accessPolicyKey(ctx contractapi.TransactionContextInterface) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(configObjectType, []string{"access"})
+}
+
+// readAccessPolicy returns the stored policy, empty when none was set.
+func readAccessPolicy(ctx contractapi.TransactionContextInterface) (*AccessPolicy, error) {
+	key, err := accessPolicyKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	policyJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	policy := AccessPolicy{Roles: map[string][]string{}}
+	if policyJSON == nil {
+		return &policy, nil
+	}
+
+	err = json.Unmarshal(policyJSON, &policy)
+	if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func putAccessPolicy(ctx contractapi.TransactionContextInterface, policy *AccessPolicy) error {
+	key, err := accessPolicyKey(ctx)
+	if err != nil {
+		return err
+	}
+	policyJSON, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, policyJSON)
+}
+
+// checkAccess runs before every transaction and rejects callers whose role
+// attribute the policy does not allow for the called function.
+func checkAccess(ctx contractapi.TransactionContextInterface) error {
+	function, _ := ctx.GetStub().GetFunctionAndParameters()
+	// "GovernanceContract:ProposeStakeholder", or the bare name on the default contract
+	if i := strings.LastIndex(function, ":"); i >= 0 {
+		function = function[i+1:]
+	}
+	if unrestricted[function] {
+		return nil
+	}
+	policy, err := readAccessPolicy(ctx)
+	if err != nil {
+		return err
+	}
+	roles, ok := policy.Roles[function]
+	if !ok {
+		return nil
+	}
+
+	role, found, err := ctx.GetClientIdentity().GetAttributeValue(roleAttribute)
+	if err != nil {
+		return fmt.Errorf("failed to read %s attribute: %v", roleAttribute, err)
+	}
+	if found {
+		for _, allowed := range roles {
+			if role == allowed {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("user not authorized: %s needs %s to be one of %v", function, roleAttribute, roles)
+}
+
+// GetBeforeTransaction makes contractapi run checkAccess before each transaction.
+func (s *SmartContract) GetBeforeTransaction() interface{} {
+	return checkAccess
+}
+
+// GetBeforeTransaction makes contractapi run checkAccess before each transaction.
+func (g *GovernanceContract) GetBeforeTransaction() interface{} {
+	return checkAccess
+}
+
+// SetAccessPolicy sets the roles allowed to call function, which must be a
+// transaction of either contract. An empty list lifts the restriction. Admin
+// only. [invoke]
+func (s *SmartContract) SetAccessPolicy(ctx contractapi.TransactionContextInterface, function string, roles []string) error {
+	if _, err := requireAdmin(ctx); err != nil {
+		return err
+	}
+	if unrestricted[function] {
+		return fmt.Errorf("%s cannot be restricted", function)
+	}
+	policy, err := readAccessPolicy(ctx)
+	if err != nil {
+		return err
+	}
+	// a stored entry can always be lifted, even if its function was removed since
+	if _, stored := policy.Roles[function]; !stored && !transactionNames[function] {
+		return fmt.Errorf("%s is not a transaction function of this chaincode", function)
+	}
+
+	if len(roles) == 0 {
+		delete(policy.Roles, function)
+	} else {
+		sorted := append([]string{}, roles...)
+		sort.Strings(sorted)
+		policy.Roles[function] = sorted
+	}
+	return putAccessPolicy(ctx, policy)
+}
+
+// GetAccessPolicy returns the function to roles policy. [query]
+func (s *SmartContract) GetAccessPolicy(ctx contractapi.TransactionContextInterface) (*AccessPolicy, error) {
+	return readAccessPolicy(ctx)
+}
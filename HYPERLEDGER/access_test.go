@@ -0,0 +1,20 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import "testing"
+
+func TestTransactionNames(t *testing.T) {
+	for _, name := range []string{"CreateAsset", "SetAccessPolicy", "ProposeStakeholder"} {
+		if !transactionNames[name] {
+			t.Errorf("%s should be a transaction function", name)
+		}
+	}
+	for _, name := range []string{"Createasset", "credit", "putAsset"} {
+		if transactionNames[name] {
+			t.Errorf("%s should not be a transaction function", name)
+		}
+	}
+}
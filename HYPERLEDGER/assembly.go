@@ -0,0 +1,176 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// OEMs solder registered chips onto boards. AssembleProduct consumes whole
+// lots the caller owns into a new composite asset that lists them in
+// Components. A consumed lot keeps its record, for provenance, but can no
+// longer be traded. GetBillOfMaterials walks the tree back to the chips.
+
+// BOMNode is one asset in a bill of materials.
+type BOMNode struct {
+	ChipID       string     `json:"id"`
+	ChipName     string     `json:"chipName"`
+	Components   []*BOMNode `json:"components"` // empty for a chip lot
+	Manufacturer string     `json:"manufacturer"`
+	Quantity     uint64     `json:"quantity"`
+}
+
+// requireTradable fails for assets that can no longer change hands.
+func requireTradable(asset *Asset) error {
+	if asset.ConsumedBy != "" {
+		return fmt.Errorf("asset %s was consumed by product %s", asset.ChipID, asset.ConsumedBy)
+	}
+	if asset.RecallID != "" {
+		return fmt.Errorf("asset %s is recalled (%s)", asset.ChipID, asset.RecallID)
+	}
+	if asset.CompromisedBy != "" {
+		return fmt.Errorf("asset %s is a confirmed counterfeit (%s)", asset.ChipID, asset.CompromisedBy)
+	}
+	return nil
+}
+
+// requireTradableLot is requireTradable for moving a lot or chips taken from
+// it. A unit recalled or confirmed counterfeit on its own holds the whole lot,
+// since the lot would otherwise carry it along.
+func requireTradableLot(ctx contractapi.TransactionContextInterface, asset *Asset) error {
+	if err := requireTradable(asset); err != nil {
+		return err
+	}
+	units, err := lotUnits(ctx, asset.ChipID)
+	if err != nil {
+		return err
+	}
+	for _, unit := range units {
+		if unit.RecallID != "" {
+			return fmt.Errorf("unit %s of asset %s is recalled (%s)", unit.Serial, asset.ChipID, unit.RecallID)
+		}
+		if unit.CompromisedBy != "" {
+			return fmt.Errorf("unit %s of asset %s is a confirmed counterfeit (%s)", unit.Serial, asset.ChipID, unit.CompromisedBy)
+		}
+	}
+	return nil
+}
+
+// AssembleProduct creates product id from the listed component lots, which
+// the caller must own. Only an active manufacturer can assemble, since the
+// product is minted under its name. The product's verification value is read from the
+// transient map like in CreateAsset. The product is added to the caller's
+// stakeholder involvedProducts. [invoke]
+func (s *SmartContract) AssembleProduct(ctx contractapi.TransactionContextInterface, id, name string, componentIDs []string) error {
+	x, err := callerStakeholder(ctx)
+	if err != nil {
+		return err
+	}
+	if err := requireActiveManufacturer(ctx, x); err != nil {
+		return err
+	}
+	if len(componentIDs) == 0 {
+		return fmt.Errorf("a product needs at least one component")
+	}
+	exists, err := s.AssetExists(ctx, id)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("the asset %s already exists", id)
+	}
+	val, err := transientVerifyValue(ctx)
+	if err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	var components []*Asset
+	for _, componentID := range componentIDs {
+		if seen[componentID] {
+			return fmt.Errorf("component %s is listed twice", componentID)
+		}
+		seen[componentID] = true
+		component, err := s.ReadAsset(ctx, componentID)
+		if err != nil {
+			return err
+		}
+		if component.Owner != x {
+			return fmt.Errorf("Client doesnt own asset %s", componentID)
+		}
+		if err := requireTradableLot(ctx, component); err != nil {
+			return err
+		}
+		if err := requireUnlocked(ctx, componentID, nil); err != nil {
+			return err
+		}
+		components = append(components, component)
+	}
+
+	old := make([]Asset, len(components))
+	for i, component := range components {
+		old[i] = *component
+		component.ConsumedBy = id
+		if err := s.putAsset(ctx, component); err != nil {
+			return err
+		}
+	}
+	salt := ctx.GetStub().GetTxID()
+	product := Asset{
+		ChipID:       id,
+		ChipName:     name,
+		Components:   componentIDs,
+		Manufacturer: x,
+		MergedFrom:   []string{},
+		Owner:        x,
+		Quantity:     1,
+		VerifyHash:   hashVerifyValue(salt, val),
+		VerifySalt:   salt,
+	}
+	if err := s.putAsset(ctx, &product); err != nil {
+		return err
+	}
+	if err := putVerifySecret(ctx, x, id, salt, val); err != nil {
+		return err
+	}
+
+	stakeholder, err := readStakeholder(ctx, x)
+	if err != nil {
+		return err
+	}
+	stakeholder.InvolvedProducts = append(stakeholder.InvolvedProducts, id)
+	if err := putStakeholder(ctx, stakeholder); err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, EventProductAssembled, id, old, &product)
+}
+
+// GetBillOfMaterials returns the component tree of an asset, down to each
+// chip lot and the manufacturer that minted it. [query]
+func (s *SmartContract) GetBillOfMaterials(ctx contractapi.TransactionContextInterface, id string) (*BOMNode, error) {
+	asset, err := s.ReadAsset(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &BOMNode{
+		ChipID:       asset.ChipID,
+		ChipName:     asset.ChipName,
+		Components:   []*BOMNode{},
+		Manufacturer: asset.Manufacturer,
+		Quantity:     asset.Quantity,
+	}
+	for _, componentID := range asset.Components {
+		component, err := s.GetBillOfMaterials(ctx, componentID)
+		if err != nil {
+			return nil, err
+		}
+		node.Components = append(node.Components, component)
+	}
+	return node, nil
+}
@@ -0,0 +1,50 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import "testing"
+
+func TestOnlyActiveManufacturersAssemble(t *testing.T) {
+	s := &SmartContract{}
+	ctx := newTestContext("Org2MSP")
+	addStakeholders(t, ctx, "Org1MSP", "Org2MSP")
+	lot := &Asset{ChipID: "lotA", DocType: "asset", Owner: "Org2MSP", Quantity: 10}
+	if err := s.putAsset(ctx, lot); err != nil {
+		t.Fatal(err)
+	}
+	if err := putManufacturer(ctx, &Manufacturer{MSPID: "Org1MSP", Status: ManufacturerSuspended}); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+	ctx.stub.transient = map[string][]byte{transientVerifyKey: []byte("value")}
+
+	if err := s.AssembleProduct(ctx, "board1", "Board", []string{"lotA"}); err == nil {
+		t.Error("a stakeholder that is no manufacturer assembled a product")
+	}
+	lot.Owner = "Org1MSP"
+	if err := s.putAsset(ctx, lot); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+	if err := s.AssembleProduct(ctx.as("Org1MSP"), "board1", "Board", []string{"lotA"}); err == nil {
+		t.Error("a suspended manufacturer assembled a product")
+	}
+
+	if err := putManufacturer(ctx, &Manufacturer{MSPID: "Org1MSP", Status: ManufacturerActive}); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+	if err := s.AssembleProduct(ctx.as("Org1MSP"), "board1", "Board", []string{"lotA"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+	product, err := s.ReadAsset(ctx, "board1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if product.Manufacturer != "Org1MSP" {
+		t.Errorf("manufacturer: got %s, want Org1MSP", product.Manufacturer)
+	}
+}
@@ -0,0 +1,133 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Authenticity verdicts returned by VerifyAuthenticity.
+const (
+	AuthenticGenuine     = "GENUINE"
+	AuthenticUnknown     = "UNKNOWN" // no such chip, or the code does not match
+	AuthenticRecalled    = "RECALLED"
+	AuthenticCounterfeit = "REPORTED_COUNTERFEIT"
+	AuthenticConsumed    = "CONSUMED" // already assembled into a product
+)
+
+// Coarse custody summaries, ownership details are never returned.
+const (
+	CustodyManufacturer = "MANUFACTURER" // never left the minting org
+	CustodySupplyChain  = "SUPPLY_CHAIN" // moved on from the manufacturer
+	CustodyAssembled    = "ASSEMBLED"    // consumed into a product
+)
+
+// AuthenticityResult answers VerifyAuthenticity.
+type AuthenticityResult struct {
+	Custody      string `json:"custody"`
+	Holders      int    `json:"holders"`      // distinct orgs that have held it, including the manufacturer
+	Manufacturer string `json:"manufacturer"` // "" when the verdict is UNKNOWN
+	OpenReports  int    `json:"openReports"`  // counterfeit reports still under investigation
+	Verdict      string `json:"verdict"`
+}
+
+// VerifyAuthenticity checks a code read off a chip against the hash its
+// manufacturer registered. chipID is a lot ID or a unit serial. Nothing about
+// the stored value is returned, and only a coarse custody summary. [query]
+func (s *SmartContract) VerifyAuthenticity(ctx contractapi.TransactionContextInterface, chipID, presentedCode string) (*AuthenticityResult, error) {
+	unknown := &AuthenticityResult{Verdict: AuthenticUnknown}
+	if presentedCode == "" {
+		return nil, fmt.Errorf("a code must be presented")
+	}
+
+	var lot *Asset
+	var unit *Unit
+	exists, err := s.AssetExists(ctx, chipID)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		lot, err = s.ReadAsset(ctx, chipID)
+		if err != nil {
+			return nil, err
+		}
+		if hashVerifyValue(lot.VerifySalt, presentedCode) != lot.VerifyHash {
+			return unknown, nil
+		}
+	} else {
+		unit, err = readUnit(ctx, chipID)
+		if err != nil {
+			return unknown, nil
+		}
+		if hashVerifyValue(unit.VerifySalt, presentedCode) != unit.VerifyHash {
+			return unknown, nil
+		}
+		lot, err = s.ReadAsset(ctx, unit.LotID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := &AuthenticityResult{
+		Custody:      CustodySupplyChain,
+		Manufacturer: lot.Manufacturer,
+		Verdict:      AuthenticGenuine,
+	}
+	owner := lot.Owner
+	if unit != nil {
+		owner = unit.Owner
+	}
+	if owner == lot.Manufacturer {
+		result.Custody = CustodyManufacturer
+	}
+
+	// holders across the lot's lineage, or the unit's own history
+	holders := map[string]bool{}
+	if unit != nil {
+		history, err := s.GetUnitHistory(ctx, chipID)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range history {
+			if entry.Unit != nil {
+				holders[entry.Unit.Owner] = true
+			}
+		}
+	} else {
+		history, err := s.GetAssetHistory(ctx, chipID)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range history {
+			if entry.Asset != nil {
+				holders[entry.Asset.Owner] = true
+			}
+		}
+	}
+	result.Holders = len(holders)
+
+	reports, err := reportsByIndex(ctx, reportTargetIndex, chipID)
+	if err != nil {
+		return nil, err
+	}
+	for _, report := range reports {
+		if report.Status == ReportOpen {
+			result.OpenReports++
+		}
+	}
+
+	switch {
+	case lot.CompromisedBy != "" || (unit != nil && unit.CompromisedBy != ""):
+		result.Verdict = AuthenticCounterfeit
+	case lot.RecallID != "" || (unit != nil && unit.RecallID != ""):
+		result.Verdict = AuthenticRecalled
+	case lot.ConsumedBy != "":
+		result.Verdict = AuthenticConsumed
+		result.Custody = CustodyAssembled
+	}
+	return result, nil
+}
@@ -0,0 +1,69 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// CancelEscrow withdraws an order the receiver has not funded yet. Sender only. [invoke]
+// DRAFTED -> CANCELLED
+func (s *SmartContract) CancelEscrow(ctx contractapi.TransactionContextInterface, txn string) error {
+	escrow, err := s.readEscrow(ctx, txn)
+	if err != nil {
+		return err
+	}
+	if _, err := requireCaller(ctx, escrow.Sender, "sender (A)"); err != nil {
+		return err
+	}
+	if escrow.Status != StatusDrafted {
+		return fmt.Errorf("escrow %s is %s, only a %s escrow can be cancelled, use MutualAbort", txn, escrow.Status, StatusDrafted)
+	}
+
+	return s.settleOutcome(ctx, escrow, OutcomeCancelled)
+}
+
+// MutualAbort records the caller's approval to abort a funded escrow. Once
+// both sender and receiver approved, the escrow amount goes back to the
+// receiver, any stake back to the carrier, and the asset stays with the
+// sender. An org that is both sender and receiver approves alone. [invoke]
+// FUNDED | HANDED_TO_CARRIER | DELIVERED -> CANCELLED
+func (s *SmartContract) MutualAbort(ctx contractapi.TransactionContextInterface, txn string) error {
+	escrow, err := s.readEscrow(ctx, txn)
+	if err != nil {
+		return err
+	}
+	old := *escrow
+	x, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+	if x != escrow.Sender && x != escrow.Receiver {
+		return fmt.Errorf("only sender (A) or receiver (B) can approve an abort")
+	}
+	if escrow.Status == StatusDrafted {
+		return fmt.Errorf("escrow %s is not funded yet, the sender can use CancelEscrow", txn)
+	}
+	if !canTransition(escrow.Status, StatusCancelled) {
+		return fmt.Errorf("escrow %s is %s and can no longer be aborted", txn, escrow.Status)
+	}
+	for _, approver := range escrow.AbortApprovals {
+		if approver == x {
+			return fmt.Errorf("%s has already approved aborting escrow %s", x, txn)
+		}
+	}
+
+	needed := 2
+	if escrow.Sender == escrow.Receiver {
+		needed = 1
+	}
+	escrow.AbortApprovals = append(escrow.AbortApprovals, x)
+	if len(escrow.AbortApprovals) < needed {
+		return s.recordEscrow(ctx, EventAbortApproved, &old, escrow)
+	}
+	return s.settleOutcome(ctx, escrow, OutcomeMutualAbort)
+}
@@ -0,0 +1,47 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import "testing"
+
+func TestMutualAbortNeedsSenderAndReceiver(t *testing.T) {
+	s := &SmartContract{}
+	ctx := newTestContext("Org1MSP")
+	if err := s.putEscrow(ctx, escrowFixture(t, s, ctx)); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+
+	if err := s.MutualAbort(ctx, "escrow1"); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+	requireEscrowStatus(t, s, ctx, "escrow1", StatusDelivered)
+
+	if err := s.MutualAbort(ctx.as("Org2MSP"), "escrow1"); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+	requireEscrowStatus(t, s, ctx, "escrow1", StatusCancelled)
+	requireBalance(t, s, ctx, "Org2MSP", 100)
+}
+
+func TestMutualAbortBySoleSenderAndReceiver(t *testing.T) {
+	s := &SmartContract{}
+	ctx := newTestContext("Org1MSP")
+	escrow := escrowFixture(t, s, ctx)
+	escrow.Receiver = escrow.Sender
+	if err := s.putEscrow(ctx, escrow); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+
+	if err := s.MutualAbort(ctx, "escrow1"); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+	requireEscrowStatus(t, s, ctx, "escrow1", StatusCancelled)
+	requireBalance(t, s, ctx, "Org1MSP", 100)
+}
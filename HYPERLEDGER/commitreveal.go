@@ -0,0 +1,177 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Product verification is commit-reveal so whoever submits last cannot copy
+// the other side. Sender (A) and receiver (B) each commit
+// hashVerifyValue(nonce, observedValue), computed off-chain. Once the goods
+// are delivered and both commitments are in, the reveal phase opens for
+// revealWindow seconds. Each side then reveals value and nonce through the
+// transient map, and the second reveal settles the escrow against the
+// manufacturer's hash. After the deadline VerifyProduct settles with whatever
+// was revealed.
+
+// revealWindow is how long, in seconds, the reveal phase stays open.
+const revealWindow = 24 * 60 * 60
+
+// transientNonceKey is the transient map entry carrying the commitment nonce.
+const transientNonceKey = "nonce"
+
+// openRevealPhase starts the reveal clock once delivery is confirmed and both
+// sides have committed.
+func openRevealPhase(ctx contractapi.TransactionContextInterface, escrow *EscrowContract) error {
+	if escrow.Status != StatusDelivered || escrow.RevealDeadline != 0 {
+		return nil
+	}
+	// escrows migrated from the old layout carry the sender's value as already revealed
+	senderIn := escrow.SenderCommitment != "" || escrow.SenderReveal != ""
+	if !senderIn || escrow.ReceiverCommitment == "" {
+		return nil
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	escrow.RevealDeadline = now + revealWindow
+	return nil
+}
+
+// CommitVerification records the caller's commitment to its observed value.
+// The sender may commit any time before the reveal phase, the receiver only
+// after delivery. A commitment cannot be changed. [invoke]
+func (s *SmartContract) CommitVerification(ctx contractapi.TransactionContextInterface, txn, commitment string) error {
+	escrow, err := s.readEscrow(ctx, txn)
+	if err != nil {
+		return err
+	}
+	old := *escrow
+	x, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+	if err := requireActiveStakeholder(ctx, x); err != nil {
+		return err
+	}
+	if escrow.Status.IsFinal() {
+		return fmt.Errorf("escrow %s is already %s", txn, escrow.Status)
+	}
+	if commitment == "" {
+		return fmt.Errorf("commitment must not be empty")
+	}
+
+	switch x {
+	case escrow.Sender:
+		if escrow.SenderCommitment != "" {
+			return fmt.Errorf("sender (A) has already committed for escrow %s", txn)
+		}
+		escrow.SenderCommitment = commitment
+	case escrow.Receiver:
+		if escrow.Status != StatusDelivered {
+			return fmt.Errorf("receiver (B) can only commit after delivery, escrow %s is %s", txn, escrow.Status)
+		}
+		if escrow.ReceiverCommitment != "" {
+			return fmt.Errorf("receiver (B) has already committed for escrow %s", txn)
+		}
+		escrow.ReceiverCommitment = commitment
+	default:
+		return fmt.Errorf("only sender (A) or receiver (B) can commit a verification value")
+	}
+
+	if err := openRevealPhase(ctx, escrow); err != nil {
+		return err
+	}
+	return s.recordEscrow(ctx, EventVerificationCommitted, &old, escrow)
+}
+
+// RevealVerification opens the caller's commitment. The value and nonce come
+// from the transient map. When both sides have revealed the escrow settles. [invoke]
+func (s *SmartContract) RevealVerification(ctx contractapi.TransactionContextInterface, txn string) error {
+	escrow, err := s.readEscrow(ctx, txn)
+	if err != nil {
+		return err
+	}
+	old := *escrow
+	x, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+	if err := requireActiveStakeholder(ctx, x); err != nil {
+		return err
+	}
+	if escrow.Status != StatusDelivered {
+		return fmt.Errorf("escrow %s is %s, values can only be revealed while it is %s", txn, escrow.Status, StatusDelivered)
+	}
+	if escrow.RevealDeadline == 0 {
+		return fmt.Errorf("reveal phase for escrow %s has not started", txn)
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	if now > escrow.RevealDeadline {
+		return fmt.Errorf("reveal phase for escrow %s has ended", txn)
+	}
+
+	value, err := transientVerifyValue(ctx)
+	if err != nil {
+		return err
+	}
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("failed to read transient map: %v", err)
+	}
+	nonce, ok := transientMap[transientNonceKey]
+	if !ok || len(nonce) == 0 {
+		return fmt.Errorf("%s must be supplied in the transient map", transientNonceKey)
+	}
+	opened := hashVerifyValue(string(nonce), value)
+
+	revealed, err := s.revealedVerifyHash(ctx, escrow, value)
+	if err != nil {
+		return err
+	}
+
+	switch x {
+	case escrow.Sender:
+		if escrow.SenderReveal != "" {
+			return fmt.Errorf("sender (A) has already revealed for escrow %s", txn)
+		}
+		if opened != escrow.SenderCommitment {
+			return fmt.Errorf("revealed value does not match the sender's commitment")
+		}
+		escrow.SenderReveal = revealed
+	case escrow.Receiver:
+		if escrow.ReceiverReveal != "" {
+			return fmt.Errorf("receiver (B) has already revealed for escrow %s", txn)
+		}
+		if opened != escrow.ReceiverCommitment {
+			return fmt.Errorf("revealed value does not match the receiver's commitment")
+		}
+		escrow.ReceiverReveal = revealed
+	default:
+		return fmt.Errorf("only sender (A) or receiver (B) can reveal a verification value")
+	}
+
+	if len(escrow.Lines) > 0 {
+		if err := s.revealLines(ctx, escrow, x == escrow.Sender, value); err != nil {
+			return err
+		}
+	} else if len(escrow.Serials) > 0 {
+		if err := s.revealUnits(ctx, escrow, x == escrow.Sender, value); err != nil {
+			return err
+		}
+	}
+
+	if escrow.SenderReveal != "" && escrow.ReceiverReveal != "" {
+		return s.settleVerification(ctx, escrow)
+	}
+	return s.recordEscrow(ctx, EventVerificationRevealed, &old, escrow)
+}
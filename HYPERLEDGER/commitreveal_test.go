@@ -0,0 +1,149 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import "testing"
+
+func TestSettleVerificationFindings(t *testing.T) {
+	good := hashVerifyValue("salt", "value")
+	bad := hashVerifyValue("salt", "other")
+	tests := []struct {
+		name       string
+		aValue     string
+		bValue     string
+		outcome    SettlementOutcome
+		status     EscrowStatus
+		senderGets uint64
+	}{
+		{"all match", good, good, OutcomeAllMatch, StatusVerified, 100},
+		{"receiver mismatch", good, bad, OutcomeCarrierAtFault, StatusDisputed, 0},
+		{"sender mismatch", bad, good, OutcomeSenderAtFault, StatusDisputed, 0},
+		{"receiver never revealed", good, "", OutcomeVerifyTimeout, StatusVerified, 100},
+		{"receiver never revealed, sender wrong", bad, "", OutcomeSenderAtFault, StatusDisputed, 0},
+		{"sender never revealed", "", good, OutcomeSenderAtFault, StatusDisputed, 0},
+		{"nobody revealed", "", "", OutcomeSenderAtFault, StatusDisputed, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &SmartContract{}
+			ctx := newTestContext("Org2MSP")
+			escrow := escrowFixture(t, s, ctx)
+			escrow.SenderReveal, escrow.ReceiverReveal = tt.aValue, tt.bValue
+
+			if err := s.settleVerification(ctx, escrow); err != nil {
+				t.Fatal(err)
+			}
+			ctx.stub.commit()
+
+			if escrow.Outcome != tt.outcome || escrow.Status != tt.status {
+				t.Errorf("got %s/%s, want %s/%s", escrow.Outcome, escrow.Status, tt.outcome, tt.status)
+			}
+			requireBalance(t, s, ctx, "Org1MSP", tt.senderGets)
+		})
+	}
+}
+
+// commitFor commits and later reveals value for the calling party.
+func commitFor(t *testing.T, s *SmartContract, ctx *testContext, mspID, value string) {
+	t.Helper()
+	nonce := "nonce-" + mspID
+	if err := s.CommitVerification(ctx.as(mspID), "escrow1", hashVerifyValue(nonce, value)); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+}
+
+func revealFor(t *testing.T, s *SmartContract, ctx *testContext, mspID, value string) {
+	t.Helper()
+	ctx.stub.transient = map[string][]byte{transientVerifyKey: []byte(value), transientNonceKey: []byte("nonce-" + mspID)}
+	if err := s.RevealVerification(ctx.as(mspID), "escrow1"); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+}
+
+func TestWithheldReceiverRevealAcceptsTheGoods(t *testing.T) {
+	s := &SmartContract{}
+	ctx := newTestContext("Org1MSP")
+	addStakeholders(t, ctx, "Org1MSP", "Org2MSP", "Org3MSP")
+	escrow := escrowFixture(t, s, ctx)
+	escrow.SenderReveal, escrow.ReceiverReveal = "", ""
+	if err := s.putEscrow(ctx, escrow); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+
+	commitFor(t, s, ctx, "Org1MSP", "value")
+	commitFor(t, s, ctx, "Org2MSP", "value")
+	revealFor(t, s, ctx, "Org1MSP", "value")
+
+	if err := s.VerifyProduct(ctx.as("Org3MSP"), "escrow1"); err == nil {
+		t.Fatal("VerifyProduct settled before the reveal deadline")
+	}
+	ctx.stub.now += revealWindow + 1
+	if err := s.VerifyProduct(ctx, "escrow1"); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+
+	settled, err := s.ReadEscrow(ctx, "escrow1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settled.Outcome != OutcomeVerifyTimeout {
+		t.Errorf("outcome: got %s, want %s", settled.Outcome, OutcomeVerifyTimeout)
+	}
+	requireBalance(t, s, ctx, "Org1MSP", 100)
+	requireBalance(t, s, ctx, "Org3MSP", 50)
+}
+
+func TestWithheldSenderRevealBlamesTheSender(t *testing.T) {
+	s := &SmartContract{}
+	ctx := newTestContext("Org1MSP")
+	addStakeholders(t, ctx, "Org1MSP", "Org2MSP", "Org3MSP", "Org4MSP")
+	escrow := escrowFixture(t, s, ctx)
+	escrow.SenderReveal, escrow.ReceiverReveal = "", ""
+	if err := s.putEscrow(ctx, escrow); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+
+	commitFor(t, s, ctx, "Org1MSP", "value")
+	commitFor(t, s, ctx, "Org2MSP", "value")
+	revealFor(t, s, ctx, "Org2MSP", "value")
+	ctx.stub.now += revealWindow + 1
+	if err := s.VerifyProduct(ctx.as("Org2MSP"), "escrow1"); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+
+	settled, err := s.ReadEscrow(ctx, "escrow1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settled.Outcome != OutcomeSenderAtFault || settled.Status != StatusDisputed {
+		t.Errorf("got %s/%s, want %s/%s", settled.Outcome, settled.Status, OutcomeSenderAtFault, StatusDisputed)
+	}
+}
+
+func TestRevealRejectedOutsideDelivered(t *testing.T) {
+	s := &SmartContract{}
+	ctx := newTestContext("Org1MSP")
+	addStakeholders(t, ctx, "Org1MSP", "Org2MSP", "Org3MSP")
+	escrow := escrowFixture(t, s, ctx)
+	escrow.RevealDeadline = 100
+	escrow.SenderCommitment = hashVerifyValue("nonce-Org1MSP", "value")
+	escrow.SenderReveal = ""
+	escrow.Status = StatusRecallHold
+	if err := s.putEscrow(ctx, escrow); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+
+	ctx.stub.transient = map[string][]byte{transientVerifyKey: []byte("value"), transientNonceKey: []byte("nonce-Org1MSP")}
+	if err := s.RevealVerification(ctx, "escrow1"); err == nil {
+		t.Error("reveal accepted on a RECALL_HOLD escrow")
+	}
+}
@@ -0,0 +1,95 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Config holds network-wide settings written once by InitLedger.
+type Config struct {
+	Admin              string `json:"admin"`              // MSP ID that mints tokens and manages manufacturers
+	AllowSharedParties bool   `json:"allowSharedParties"` // see SetPartyPolicy
+	Arbiter            string `json:"arbiter"`            // MSP ID that rules on disputes, "" for a stakeholder panel
+	PenaltyThreshold   uint64 `json:"penaltyThreshold"`   // see SetPenaltyThreshold, 0 = never suspend
+}
+
+func configKey(ctx contractapi.TransactionContextInterface) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(configObjectType, []string{"network"})
+}
+
+// readConfig returns the network config, or nil before InitLedger has run.
+func readConfig(ctx contractapi.TransactionContextInterface) (*Config, error) {
+	key, err := configKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	configJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if configJSON == nil {
+		return nil, nil
+	}
+
+	var config Config
+	err = json.Unmarshal(configJSON, &config)
+	if err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func putConfig(ctx contractapi.TransactionContextInterface, config *Config) error {
+	key, err := configKey(ctx)
+	if err != nil {
+		return err
+	}
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, configJSON)
+}
+
+// getAdmin returns the admin MSP ID recorded by InitLedger.
+func getAdmin(ctx contractapi.TransactionContextInterface) (string, error) {
+	config, err := readConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+	if config == nil {
+		return "", fmt.Errorf("ledger is not initialised, run InitLedger first")
+	}
+	return config.Admin, nil
+}
+
+// SetPartyPolicy decides whether one org may play more than one of sender,
+// receiver and carrier in an escrow, e.g. a manufacturer shipping with its
+// own fleet. Admin only. [invoke]
+func (s *SmartContract) SetPartyPolicy(ctx contractapi.TransactionContextInterface, allowShared bool) error {
+	if _, err := requireAdmin(ctx); err != nil {
+		return err
+	}
+	config, err := readConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	config.AllowSharedParties = allowShared
+	return putConfig(ctx, config)
+}
+
+// requireAdmin fails unless the caller belongs to the admin org.
+func requireAdmin(ctx contractapi.TransactionContextInterface) (string, error) {
+	admin, err := getAdmin(ctx)
+	if err != nil {
+		return "", err
+	}
+	return requireCaller(ctx, admin, "the admin org")
+}
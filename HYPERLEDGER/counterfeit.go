@@ -0,0 +1,286 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Any channel member that finds a suspicious part files a report against the
+// chip lot or unit serial. The manufacturer that minted it investigates and
+// confirms or rejects the report. A confirmed report marks the lot or unit
+// compromised: it can no longer be traded and escrow verification on it
+// fails.
+
+// Counterfeit report statuses.
+const (
+	ReportOpen      = "OPEN"
+	ReportConfirmed = "CONFIRMED"
+	ReportRejected  = "REJECTED"
+)
+
+type CounterfeitReport struct {
+	EvidenceHash string `json:"evidenceHash"` // hash of the off-chain evidence
+	ID           string `json:"id"`           // txn ID of the ReportCounterfeit call
+	LotID        string `json:"lotID"`        // the lot itself, or the lot the unit belongs to
+	Location     string `json:"location"`
+	Manufacturer string `json:"manufacturer"` // investigates the report
+	Note         string `json:"note"`         // manufacturer's finding
+	ReportedAt   int64  `json:"reportedAt"`
+	Reporter     string `json:"reporter"`
+	ResolvedAt   int64  `json:"resolvedAt"`
+	Serial       string `json:"serial"` // "" when the whole lot was reported
+	Status       string `json:"status"`
+	Target       string `json:"target"` // chip ID or serial as reported
+}
+
+func readCounterfeitReport(ctx contractapi.TransactionContextInterface, id string) (*CounterfeitReport, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(reportObjectType, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	reportJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if reportJSON == nil {
+		return nil, fmt.Errorf("the counterfeit report %s does not exist", id)
+	}
+
+	var report CounterfeitReport
+	err = json.Unmarshal(reportJSON, &report)
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// putCounterfeitReport writes the report and its target and reporter index
+// entries. A unit report is listed under its lot as well.
+func putCounterfeitReport(ctx contractapi.TransactionContextInterface, report *CounterfeitReport) error {
+	key, err := ctx.GetStub().CreateCompositeKey(reportObjectType, []string{report.ID})
+	if err != nil {
+		return err
+	}
+	indexes := []struct{ name, value string }{
+		{reportTargetIndex, report.Target},
+		{reporterIndex, report.Reporter},
+	}
+	if report.Serial != "" {
+		indexes = append(indexes, struct{ name, value string }{reportTargetIndex, report.LotID})
+	}
+	for _, index := range indexes {
+		indexKey, err := ctx.GetStub().CreateCompositeKey(index.name, []string{index.value, report.ID})
+		if err != nil {
+			return err
+		}
+		if err := ctx.GetStub().PutState(indexKey, []byte{0x00}); err != nil {
+			return err
+		}
+	}
+	reportJSON, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, reportJSON)
+}
+
+// reportsByIndex loads every report listed under one index value.
+func reportsByIndex(ctx contractapi.TransactionContextInterface, index, value string) ([]*CounterfeitReport, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(index, []string{value})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	reports := []*CounterfeitReport{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		report, err := readCounterfeitReport(ctx, attributes[1])
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// ReportCounterfeit files a report against a chip lot ID or a unit serial.
+// Any channel member can report. [invoke]
+func (s *SmartContract) ReportCounterfeit(ctx contractapi.TransactionContextInterface, target, evidenceHash, location string) (*CounterfeitReport, error) {
+	x, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client identity: %v", err)
+	}
+	if evidenceHash == "" {
+		return nil, fmt.Errorf("evidence hash must not be empty")
+	}
+
+	lotID, serial := target, ""
+	exists, err := s.AssetExists(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		unit, err := readUnit(ctx, target)
+		if err != nil {
+			return nil, fmt.Errorf("%s is neither a chip ID nor a unit serial", target)
+		}
+		lotID, serial = unit.LotID, unit.Serial
+	}
+	lot, err := s.ReadAsset(ctx, lotID)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := CounterfeitReport{
+		EvidenceHash: evidenceHash,
+		ID:           ctx.GetStub().GetTxID(),
+		LotID:        lotID,
+		Location:     location,
+		Manufacturer: lot.Manufacturer,
+		ReportedAt:   now,
+		Reporter:     x,
+		Serial:       serial,
+		Status:       ReportOpen,
+		Target:       target,
+	}
+	if err := putCounterfeitReport(ctx, &report); err != nil {
+		return nil, err
+	}
+	return &report, emitEvent(ctx, EventCounterfeitReported, report.ID, nil, &report)
+}
+
+// ResolveCounterfeitReport records the manufacturer's finding on an open
+// report. confirm=true marks the reported lot or unit compromised. [invoke]
+func (s *SmartContract) ResolveCounterfeitReport(ctx contractapi.TransactionContextInterface, id string, confirm bool, note string) error {
+	report, err := readCounterfeitReport(ctx, id)
+	if err != nil {
+		return err
+	}
+	if _, err := requireCaller(ctx, report.Manufacturer, "the minting manufacturer"); err != nil {
+		return err
+	}
+	if report.Status != ReportOpen {
+		return fmt.Errorf("counterfeit report %s is already %s", id, report.Status)
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	old := *report
+	report.Note = note
+	report.ResolvedAt = now
+	report.Status = ReportRejected
+	if confirm {
+		report.Status = ReportConfirmed
+		if report.Serial != "" {
+			unit, err := readUnit(ctx, report.Serial)
+			if err != nil {
+				return err
+			}
+			unit.CompromisedBy = id
+			if err := putUnit(ctx, unit); err != nil {
+				return err
+			}
+		} else {
+			lot, err := s.survivingLot(ctx, report.Manufacturer, report.LotID)
+			if err != nil {
+				return err
+			}
+			lot.CompromisedBy = id
+			if err := s.putAsset(ctx, lot); err != nil {
+				return err
+			}
+		}
+	}
+	if err := putCounterfeitReport(ctx, report); err != nil {
+		return err
+	}
+	return emitEvent(ctx, EventCounterfeitResolved, id, &old, report)
+}
+
+// survivingLot returns lot lotID, or, when MergeAssets has folded it away,
+// the lot that now holds its chips. Merging keeps the manufacturer, so only
+// its lots need to be searched.
+func (s *SmartContract) survivingLot(ctx contractapi.TransactionContextInterface, manufacturer, lotID string) (*Asset, error) {
+	exists, err := s.AssetExists(ctx, lotID)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return s.ReadAsset(ctx, lotID)
+	}
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(manufacturerIndex, []string{manufacturer})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+	minted, err := s.assetsFromIndex(ctx, resultsIterator)
+	if err != nil {
+		return nil, err
+	}
+	for _, asset := range minted {
+		for _, mergedID := range asset.MergedFrom {
+			if mergedID == lotID {
+				return asset, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("the lot %s does not exist", lotID)
+}
+
+// escrowCompromised reports whether the lot, or a unit, an escrow sells was
+// confirmed counterfeit.
+func (s *SmartContract) escrowCompromised(ctx contractapi.TransactionContextInterface, escrow *EscrowContract) (bool, error) {
+	lot, err := s.ReadAsset(ctx, escrow.AssetID)
+	if err != nil {
+		return false, err
+	}
+	if lot.CompromisedBy != "" {
+		return true, nil
+	}
+	for _, serial := range escrow.Serials {
+		unit, err := readUnit(ctx, serial)
+		if err != nil {
+			return false, err
+		}
+		if unit.CompromisedBy != "" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetCounterfeitReport returns one report. [query]
+func (s *SmartContract) GetCounterfeitReport(ctx contractapi.TransactionContextInterface, id string) (*CounterfeitReport, error) {
+	return readCounterfeitReport(ctx, id)
+}
+
+// GetReportsByTarget returns every report filed against a chip ID or serial.
+// For a chip ID this includes the reports on its units. [query]
+func (s *SmartContract) GetReportsByTarget(ctx contractapi.TransactionContextInterface, target string) ([]*CounterfeitReport, error) {
+	return reportsByIndex(ctx, reportTargetIndex, target)
+}
+
+// GetReportsByReporter returns every report filed by an org. [query]
+func (s *SmartContract) GetReportsByReporter(ctx contractapi.TransactionContextInterface, reporter string) ([]*CounterfeitReport, error) {
+	return reportsByIndex(ctx, reporterIndex, reporter)
+}
@@ -0,0 +1,71 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import "testing"
+
+func TestUnitReportsAreListedUnderTheirLot(t *testing.T) {
+	s := &SmartContract{}
+	ctx := newTestContext("Org2MSP")
+	lot := &Asset{ChipID: "lot1", DocType: "asset", Manufacturer: "Org1MSP", Owner: "Org1MSP", Quantity: 2}
+	if err := s.putAsset(ctx, lot); err != nil {
+		t.Fatal(err)
+	}
+	if err := putUnit(ctx, &Unit{LotID: "lot1", Owner: "Org1MSP", Serial: "s1"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+
+	if _, err := s.ReportCounterfeit(ctx, "s1", "evidence", "Pune"); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+
+	for _, target := range []string{"s1", "lot1"} {
+		reports, err := s.GetReportsByTarget(ctx, target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reports) != 1 || reports[0].Serial != "s1" {
+			t.Errorf("reports on %s: got %d, want the report on s1", target, len(reports))
+		}
+	}
+}
+
+func TestReportOnAMergedLotMarksTheLotThatAbsorbedIt(t *testing.T) {
+	s := &SmartContract{}
+	ctx := newTestContext("Org1MSP")
+	for _, id := range []string{"lotA", "lotB", "lotC"} {
+		lot := &Asset{ChipID: id, ChipName: "chip", DocType: "asset", Manufacturer: "Org1MSP", MergedFrom: []string{}, Owner: "Org1MSP", Quantity: 10, VerifyHash: "hash"}
+		if err := s.putAsset(ctx, lot); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx.stub.commit()
+	report, err := s.ReportCounterfeit(ctx.as("Org2MSP"), "lotA", "evidence", "Pune")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+	for _, merge := range [][2]string{{"lotB", "lotA"}, {"lotC", "lotB"}} {
+		if err := s.MergeAssets(ctx.as("Org1MSP"), merge[0], merge[1]); err != nil {
+			t.Fatal(err)
+		}
+		ctx.stub.commit()
+	}
+
+	if err := s.ResolveCounterfeitReport(ctx, report.ID, true, "fake"); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+
+	lot, err := s.ReadAsset(ctx, "lotC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lot.CompromisedBy != report.ID || lot.Quantity != 30 {
+		t.Errorf("lotC: got compromised by %q with %d chips, want %q with 30", lot.CompromisedBy, lot.Quantity, report.ID)
+	}
+}
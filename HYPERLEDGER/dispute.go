@@ -0,0 +1,310 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// A verification mismatch moves the escrow to DISPUTED with its funds still
+// locked and opens a Dispute under the same txn ID. The parties attach
+// evidence (hashes of off-chain inspection reports, photos, ...) and the
+// configured arbiter org issues a ruling. Without an arbiter, or when the
+// arbiter is itself a party, the active stakeholders outside the escrow when
+// the dispute opened form the panel and a strict majority of it decides;
+// members may change their vote until then. The ruling settles the escrow
+// (RESOLVED). A dispute nobody decided by its ruleBy deadline, e.g. with an
+// empty or deadlocked panel, settles as a split through ClaimTimeout.
+
+// rulingWindow is how long, in seconds, a dispute waits for a ruling.
+const rulingWindow = 14 * 24 * 60 * 60
+
+// Rulings accepted by IssueRuling.
+const (
+	RulingCarrierAtFault = "CARRIER_AT_FAULT"
+	RulingSenderAtFault  = "SENDER_AT_FAULT"
+	RulingNoFault        = "NO_FAULT"
+	RulingSplit          = "SPLIT"
+)
+
+var rulingOutcomes = map[string]SettlementOutcome{
+	RulingCarrierAtFault: OutcomeRuledCarrierAtFault,
+	RulingSenderAtFault:  OutcomeRuledSenderAtFault,
+	RulingNoFault:        OutcomeRuledNoFault,
+	RulingSplit:          OutcomeRuledSplit,
+}
+
+const (
+	DisputeOpen  = "OPEN"
+	DisputeRuled = "RULED"
+)
+
+type Evidence struct {
+	Description string `json:"description"`
+	Hash        string `json:"hash"` // hash of the off-chain document
+	Submitter   string `json:"submitter"`
+	Timestamp   int64  `json:"timestamp"`
+}
+
+type Dispute struct {
+	EscrowID string            `json:"escrowID"`
+	Evidence []Evidence        `json:"evidence"`
+	Finding  SettlementOutcome `json:"finding"` // what automatic verification concluded
+	OpenedAt int64             `json:"openedAt"`
+	Panel    []string          `json:"panel"`  // stakeholders outside the escrow when it opened
+	RuleBy   int64             `json:"ruleBy"` // unix seconds, see ClaimTimeout
+	Ruling   string            `json:"ruling"`
+	RuledBy  string            `json:"ruledBy"` // arbiter MSP ID, "panel" or "deadline"
+	Status   string            `json:"status"`
+	Votes    map[string]string `json:"votes"` // panel member MSP ID -> ruling
+}
+
+func readDispute(ctx contractapi.TransactionContextInterface, txn string) (*Dispute, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(disputeObjectType, []string{txn})
+	if err != nil {
+		return nil, err
+	}
+	disputeJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if disputeJSON == nil {
+		return nil, fmt.Errorf("escrow %s has no dispute", txn)
+	}
+
+	var dispute Dispute
+	err = json.Unmarshal(disputeJSON, &dispute)
+	if err != nil {
+		return nil, err
+	}
+	return &dispute, nil
+}
+
+func putDispute(ctx contractapi.TransactionContextInterface, dispute *Dispute) error {
+	key, err := ctx.GetStub().CreateCompositeKey(disputeObjectType, []string{dispute.EscrowID})
+	if err != nil {
+		return err
+	}
+	disputeJSON, err := json.Marshal(dispute)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, disputeJSON)
+}
+
+// openDispute records a new dispute for an escrow that just became DISPUTED.
+func openDispute(ctx contractapi.TransactionContextInterface, escrow *EscrowContract) error {
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	panel, err := disputePanel(ctx, escrow)
+	if err != nil {
+		return err
+	}
+	return putDispute(ctx, &Dispute{
+		EscrowID: escrow.TxnID,
+		Evidence: []Evidence{},
+		Finding:  escrow.Outcome,
+		OpenedAt: now,
+		Panel:    panel,
+		RuleBy:   now + rulingWindow,
+		Status:   DisputeOpen,
+		Votes:    map[string]string{},
+	})
+}
+
+// openDisputeFor loads a DISPUTED escrow and its open dispute.
+func (s *SmartContract) openDisputeFor(ctx contractapi.TransactionContextInterface, txn string) (*EscrowContract, *Dispute, error) {
+	escrow, err := s.readEscrow(ctx, txn)
+	if err != nil {
+		return nil, nil, err
+	}
+	if escrow.Status != StatusDisputed {
+		return nil, nil, fmt.Errorf("escrow %s is %s, not %s", txn, escrow.Status, StatusDisputed)
+	}
+	dispute, err := readDispute(ctx, txn)
+	if err != nil {
+		return nil, nil, err
+	}
+	if dispute.Status != DisputeOpen {
+		return nil, nil, fmt.Errorf("dispute on escrow %s is already %s", txn, dispute.Status)
+	}
+	return escrow, dispute, nil
+}
+
+// SetArbiter names the org that rules on disputes, or "" to use the
+// stakeholder panel. Disputes on the arbiter's own escrows still go to the
+// panel. Admin only. [invoke]
+func (s *SmartContract) SetArbiter(ctx contractapi.TransactionContextInterface, mspID string) error {
+	if _, err := requireAdmin(ctx); err != nil {
+		return err
+	}
+	if mspID != "" {
+		if err := requireActiveStakeholder(ctx, mspID); err != nil {
+			return err
+		}
+	}
+	config, err := readConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	config.Arbiter = mspID
+	return putConfig(ctx, config)
+}
+
+// AddEvidence attaches the hash of an off-chain document to an open dispute.
+// Parties of the escrow only. [invoke]
+func (s *SmartContract) AddEvidence(ctx contractapi.TransactionContextInterface, txn, hash, description string) error {
+	escrow, dispute, err := s.openDisputeFor(ctx, txn)
+	if err != nil {
+		return err
+	}
+	x, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+	if x != escrow.Sender && x != escrow.Receiver && x != escrow.Delivery {
+		return fmt.Errorf("only a party of escrow %s can add evidence", txn)
+	}
+	if hash == "" {
+		return fmt.Errorf("evidence hash must not be empty")
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	old := *dispute
+	dispute.Evidence = append(dispute.Evidence, Evidence{
+		Description: description,
+		Hash:        hash,
+		Submitter:   x,
+		Timestamp:   now,
+	})
+	if err := putDispute(ctx, dispute); err != nil {
+		return err
+	}
+	return emitEvent(ctx, EventEvidenceAdded, txn, &old, dispute)
+}
+
+// IssueRuling decides an open dispute. With an arbiter configured that is not
+// a party of the escrow, only the arbiter can call it and its ruling settles
+// the escrow at once. Otherwise each panel member votes, and may change its
+// vote, until a ruling is backed by a strict majority of the panel. [invoke]
+func (s *SmartContract) IssueRuling(ctx contractapi.TransactionContextInterface, txn, ruling string) error {
+	outcome, ok := rulingOutcomes[ruling]
+	if !ok {
+		return fmt.Errorf("unknown ruling %q", ruling)
+	}
+	escrow, dispute, err := s.openDisputeFor(ctx, txn)
+	if err != nil {
+		return err
+	}
+	config, err := readConfig(ctx)
+	if err != nil {
+		return err
+	}
+	x, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+
+	old := *dispute
+	old.Votes = map[string]string{}
+	for voter, vote := range dispute.Votes {
+		old.Votes[voter] = vote
+	}
+	if config.Arbiter != "" && !escrow.hasParty(config.Arbiter) {
+		if x != config.Arbiter {
+			return fmt.Errorf("only the arbiter %s can rule on disputes", config.Arbiter)
+		}
+		dispute.RuledBy = x
+	} else {
+		if len(dispute.Panel) == 0 {
+			return fmt.Errorf("escrow %s has no panel, it settles by ClaimTimeout after %d", txn, dispute.RuleBy)
+		}
+		i := sort.SearchStrings(dispute.Panel, x)
+		if i == len(dispute.Panel) || dispute.Panel[i] != x {
+			return fmt.Errorf("%s is not on the panel for escrow %s", x, txn)
+		}
+		dispute.Votes[x] = ruling
+
+		backing := 0
+		for _, vote := range dispute.Votes {
+			if vote == ruling {
+				backing++
+			}
+		}
+		if backing*2 <= len(dispute.Panel) {
+			if err := putDispute(ctx, dispute); err != nil {
+				return err
+			}
+			return emitEvent(ctx, EventRulingVoted, txn, &old, dispute)
+		}
+		dispute.RuledBy = "panel"
+	}
+
+	dispute.Ruling = ruling
+	dispute.Status = DisputeRuled
+	if err := putDispute(ctx, dispute); err != nil {
+		return err
+	}
+	return s.settleOutcome(ctx, escrow, outcome)
+}
+
+// lapseDispute closes a dispute nobody ruled on in time as a split: B keeps
+// the goods and pays A half, D gets its stake back.
+func lapseDispute(ctx contractapi.TransactionContextInterface, dispute *Dispute) (SettlementOutcome, error) {
+	dispute.Ruling = RulingSplit
+	dispute.RuledBy = "deadline"
+	dispute.Status = DisputeRuled
+	if err := putDispute(ctx, dispute); err != nil {
+		return "", err
+	}
+	return rulingOutcomes[RulingSplit], nil
+}
+
+// disputePanel returns the active stakeholders that are not a party of the
+// escrow, sorted.
+func disputePanel(ctx contractapi.TransactionContextInterface, escrow *EscrowContract) ([]string, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(stakeholderObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	panel := []string{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var stakeholder Stakeholder
+		err = json.Unmarshal(queryResponse.Value, &stakeholder)
+		if err != nil {
+			return nil, err
+		}
+		switch stakeholder.MSPID {
+		case escrow.Sender, escrow.Receiver, escrow.Delivery:
+			continue
+		}
+		if stakeholder.Active {
+			panel = append(panel, stakeholder.MSPID)
+		}
+	}
+	sort.Strings(panel)
+	return panel, nil
+}
+
+// GetDispute returns the dispute opened on an escrow. [query]
+func (s *SmartContract) GetDispute(ctx contractapi.TransactionContextInterface, txn string) (*Dispute, error) {
+	return readDispute(ctx, txn)
+}
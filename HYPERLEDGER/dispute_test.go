@@ -0,0 +1,112 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import "testing"
+
+// disputeFixture opens a CARRIER_AT_FAULT dispute on escrowFixture with the
+// given stakeholders admitted and arbiter configured.
+func disputeFixture(t *testing.T, s *SmartContract, ctx *testContext, arbiter string, stakeholders ...string) {
+	t.Helper()
+	addStakeholders(t, ctx, stakeholders...)
+	if err := putConfig(ctx, &Config{Admin: "Org1MSP", Arbiter: arbiter}); err != nil {
+		t.Fatal(err)
+	}
+	escrow := escrowFixture(t, s, ctx)
+	escrow.ReceiverReveal = hashVerifyValue("salt", "other")
+	if err := s.settleVerification(ctx, escrow); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+	if escrow.Status != StatusDisputed {
+		t.Fatalf("status: got %s, want %s", escrow.Status, StatusDisputed)
+	}
+}
+
+func TestArbiterCannotRuleOnItsOwnEscrow(t *testing.T) {
+	s := &SmartContract{}
+	ctx := newTestContext("Org1MSP")
+	disputeFixture(t, s, ctx, "Org3MSP", "Org1MSP", "Org2MSP", "Org3MSP", "Org4MSP", "Org5MSP")
+
+	if err := s.IssueRuling(ctx.as("Org3MSP"), "escrow1", RulingNoFault); err == nil {
+		t.Fatal("the carrier ruled on its own dispute as arbiter")
+	}
+	for _, member := range []string{"Org4MSP", "Org5MSP"} {
+		if err := s.IssueRuling(ctx.as(member), "escrow1", RulingCarrierAtFault); err != nil {
+			t.Fatal(err)
+		}
+		ctx.stub.commit()
+	}
+	requireEscrowStatus(t, s, ctx, "escrow1", StatusResolved)
+	requireBalance(t, s, ctx, "Org1MSP", 50)
+	requireBalance(t, s, ctx, "Org2MSP", 100)
+}
+
+func TestPanelIsFixedWhenTheDisputeOpens(t *testing.T) {
+	s := &SmartContract{}
+	ctx := newTestContext("Org1MSP")
+	disputeFixture(t, s, ctx, "", "Org1MSP", "Org2MSP", "Org3MSP", "Org4MSP", "Org5MSP")
+	addStakeholders(t, ctx, "Org6MSP")
+
+	if err := s.IssueRuling(ctx.as("Org6MSP"), "escrow1", RulingNoFault); err == nil {
+		t.Error("a stakeholder admitted after the dispute opened voted")
+	}
+	dispute, err := readDispute(ctx, "escrow1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dispute.Panel) != 2 {
+		t.Errorf("panel: got %v, want Org4MSP and Org5MSP", dispute.Panel)
+	}
+}
+
+func TestDeadlockedPanelMemberCanChangeItsVote(t *testing.T) {
+	s := &SmartContract{}
+	ctx := newTestContext("Org1MSP")
+	disputeFixture(t, s, ctx, "", "Org1MSP", "Org2MSP", "Org3MSP", "Org4MSP", "Org5MSP")
+
+	votes := []struct{ member, ruling string }{
+		{"Org4MSP", RulingNoFault},
+		{"Org5MSP", RulingCarrierAtFault},
+		{"Org4MSP", RulingCarrierAtFault},
+	}
+	for _, vote := range votes {
+		if err := s.IssueRuling(ctx.as(vote.member), "escrow1", vote.ruling); err != nil {
+			t.Fatal(err)
+		}
+		ctx.stub.commit()
+	}
+	requireEscrowStatus(t, s, ctx, "escrow1", StatusResolved)
+}
+
+func TestUndecidedDisputeSplitsAfterItsDeadline(t *testing.T) {
+	s := &SmartContract{}
+	ctx := newTestContext("Org1MSP")
+	disputeFixture(t, s, ctx, "", "Org1MSP", "Org2MSP", "Org3MSP")
+
+	if err := s.IssueRuling(ctx.as("Org1MSP"), "escrow1", RulingNoFault); err == nil {
+		t.Fatal("ruled without a panel or arbiter")
+	}
+	if err := s.ClaimTimeout(ctx.as("Org2MSP"), "escrow1"); err == nil {
+		t.Fatal("claimed the ruling deadline early")
+	}
+	ctx.stub.now += rulingWindow + 1
+	if err := s.ClaimTimeout(ctx, "escrow1"); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+
+	requireEscrowStatus(t, s, ctx, "escrow1", StatusResolved)
+	requireBalance(t, s, ctx, "Org1MSP", 50)
+	requireBalance(t, s, ctx, "Org2MSP", 50)
+	requireBalance(t, s, ctx, "Org3MSP", 50)
+	dispute, err := readDispute(ctx, "escrow1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dispute.RuledBy != "deadline" || dispute.Ruling != RulingSplit {
+		t.Errorf("dispute: got ruling %s by %s", dispute.Ruling, dispute.RuledBy)
+	}
+}
@@ -0,0 +1,64 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"fmt"
+)
+
+// EscrowStatus is the single lifecycle state of an EscrowContract.
+type EscrowStatus string
+
+const (
+	StatusDrafted         EscrowStatus = "DRAFTED"           // written by sender (A) in Init
+	StatusFunded          EscrowStatus = "FUNDED"            // receiver (B) accepted in StartDelivery
+	StatusHandedToCarrier EscrowStatus = "HANDED_TO_CARRIER" // sender (A) gave goods to delivery (D), D staked
+	StatusDelivered       EscrowStatus = "DELIVERED"         // delivery (D) confirmed drop-off
+	StatusVerified        EscrowStatus = "VERIFIED"          // receiver (B) verified, escrow closed
+	StatusDisputed        EscrowStatus = "DISPUTED"          // verification values did not match, funds held
+	StatusResolved        EscrowStatus = "RESOLVED"          // dispute settled by a ruling
+	StatusCancelled       EscrowStatus = "CANCELLED"         // withdrawn by A, or aborted by A and B together
+	StatusExpired         EscrowStatus = "EXPIRED"           // a party missed its deadline, see ClaimTimeout
+	StatusRecallHold      EscrowStatus = "RECALL_HOLD"       // the goods were recalled, funds held, see ReleaseRecallHold
+)
+
+// escrowTransitions lists, for every status, the statuses it may move to.
+// Statuses missing from the map are final.
+var escrowTransitions = map[EscrowStatus][]EscrowStatus{
+	StatusDrafted:         {StatusFunded, StatusCancelled, StatusExpired, StatusRecallHold},
+	StatusFunded:          {StatusHandedToCarrier, StatusCancelled, StatusExpired, StatusRecallHold},
+	StatusHandedToCarrier: {StatusDelivered, StatusCancelled, StatusExpired, StatusRecallHold},
+	StatusDelivered:       {StatusVerified, StatusDisputed, StatusCancelled, StatusRecallHold},
+	StatusDisputed:        {StatusResolved},
+	StatusRecallHold:      {StatusCancelled},
+}
+
+// IsFinal reports whether no further transition is possible from this status.
+func (st EscrowStatus) IsFinal() bool {
+	_, ok := escrowTransitions[st]
+	return !ok
+}
+
+// canTransition reports whether the transition table allows from -> to.
+func canTransition(from, to EscrowStatus) bool {
+	for _, next := range escrowTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// transition moves the escrow to the given status or explains why it cannot.
+func (e *EscrowContract) transition(to EscrowStatus) error {
+	if canTransition(e.Status, to) {
+		e.Status = to
+		return nil
+	}
+	if e.Status.IsFinal() {
+		return fmt.Errorf("escrow %s is already %s and cannot move to %s", e.TxnID, e.Status, to)
+	}
+	return fmt.Errorf("escrow %s is %s, cannot move to %s (expected one of %v)", e.TxnID, e.Status, to, escrowTransitions[e.Status])
+}
@@ -0,0 +1,76 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import "testing"
+
+func TestEscrowStepsFollowTheTransitionTable(t *testing.T) {
+	s := &SmartContract{}
+	ctx := newTestContext("Org1MSP")
+	addStakeholders(t, ctx, "Org1MSP", "Org2MSP", "Org3MSP")
+	escrow := escrowFixture(t, s, ctx)
+	escrow.LockedAmount, escrow.LockedStake = 0, 0
+	escrow.ReceiverReveal, escrow.SenderReveal = "", ""
+	escrow.Status = StatusDrafted
+	if err := s.putEscrow(ctx, escrow); err != nil {
+		t.Fatal(err)
+	}
+	for account, balance := range map[string]uint64{"Org2MSP": 100, "Org3MSP": 50} {
+		if err := s.putBalance(ctx, account, balance); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx.stub.commit()
+
+	steps := []struct {
+		name   string
+		caller string
+		step   func(ctx *testContext) error
+		want   EscrowStatus // status afterwards, unchanged when the step is refused
+		ok     bool
+	}{
+		{"hand over before funding", "Org1MSP", func(ctx *testContext) error { return s.InitiateDelivery(ctx, "escrow1", true) }, StatusDrafted, false},
+		{"confirm before handover", "Org3MSP", func(ctx *testContext) error { return s.ConfirmDelivery(ctx, "escrow1", true) }, StatusDrafted, false},
+		{"abort before funding", "Org1MSP", func(ctx *testContext) error { return s.MutualAbort(ctx, "escrow1") }, StatusDrafted, false},
+		{"fund", "Org2MSP", func(ctx *testContext) error { return s.StartDelivery(ctx, "escrow1", true) }, StatusFunded, true},
+		{"fund twice", "Org2MSP", func(ctx *testContext) error { return s.StartDelivery(ctx, "escrow1", true) }, StatusFunded, false},
+		{"cancel once funded", "Org1MSP", func(ctx *testContext) error { return s.CancelEscrow(ctx, "escrow1") }, StatusFunded, false},
+		{"hand over", "Org1MSP", func(ctx *testContext) error { return s.InitiateDelivery(ctx, "escrow1", true) }, StatusHandedToCarrier, true},
+		{"verify before delivery", "Org2MSP", func(ctx *testContext) error { return s.VerifyProduct(ctx, "escrow1") }, StatusHandedToCarrier, false},
+		{"confirm delivery", "Org3MSP", func(ctx *testContext) error { return s.ConfirmDelivery(ctx, "escrow1", true) }, StatusDelivered, true},
+		{"hand over again", "Org1MSP", func(ctx *testContext) error { return s.InitiateDelivery(ctx, "escrow1", true) }, StatusDelivered, false},
+	}
+	for _, step := range steps {
+		err := step.step(ctx.as(step.caller))
+		if step.ok && err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if !step.ok && err == nil {
+			t.Errorf("%s: the step was allowed", step.name)
+		}
+		ctx.stub.commit()
+		requireEscrowStatus(t, s, ctx, "escrow1", step.want)
+	}
+	requireBalance(t, s, ctx, "Org2MSP", 0)
+	requireBalance(t, s, ctx, "Org3MSP", 0)
+}
+
+func TestFinalStatusesRefuseEveryMove(t *testing.T) {
+	statuses := []EscrowStatus{
+		StatusDrafted, StatusFunded, StatusHandedToCarrier, StatusDelivered, StatusVerified,
+		StatusDisputed, StatusResolved, StatusCancelled, StatusExpired, StatusRecallHold,
+	}
+	for _, from := range []EscrowStatus{StatusVerified, StatusResolved, StatusCancelled, StatusExpired} {
+		if !from.IsFinal() {
+			t.Errorf("%s is not final", from)
+		}
+		for _, to := range statuses {
+			escrow := &EscrowContract{Status: from, TxnID: "escrow1"}
+			if err := escrow.transition(to); err == nil || escrow.Status != from {
+				t.Errorf("%s moved to %s", from, to)
+			}
+		}
+	}
+}
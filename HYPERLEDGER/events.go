@@ -0,0 +1,76 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// eventVersion is bumped whenever LifecycleEvent changes shape.
+const eventVersion = 1
+
+// Event names. Fabric keeps only the last SetEvent of a transaction, so every
+// transaction emits exactly one of these, after its state is written.
+const (
+	EventAssetCreated          = "AssetCreated"
+	EventAssetTransferred      = "AssetTransferred"
+	EventUnitsRegistered       = "UnitsRegistered"
+	EventUnitsTransferred      = "UnitsTransferred"
+	EventAssetSplit            = "AssetSplit"
+	EventAssetsMerged          = "AssetsMerged"
+	EventProductAssembled      = "ProductAssembled"
+	EventAssetsRecalled        = "AssetsRecalled"
+	EventCounterfeitReported   = "CounterfeitReported"
+	EventCounterfeitResolved   = "CounterfeitResolved"
+	EventEscrowInitiated       = "EscrowInitiated"
+	EventEscrowFunded          = "EscrowFunded"
+	EventEscrowCancelled       = "EscrowCancelled"
+	EventDeliveryInitiated     = "DeliveryInitiated"
+	EventDeliveryConfirmed     = "DeliveryConfirmed"
+	EventVerificationCommitted = "VerificationCommitted"
+	EventVerificationRevealed  = "VerificationRevealed"
+	EventAbortApproved         = "AbortApproved"
+	EventEscrowSettled         = "EscrowSettled"
+	EventEscrowDisputed        = "EscrowDisputed"
+	EventEvidenceAdded         = "EvidenceAdded"
+	EventRulingVoted           = "RulingVoted"
+)
+
+// LifecycleEvent is the payload of every asset and escrow event. OldState and
+// NewState hold the full record before and after the transaction (OldState is
+// null on creation), so listeners do not need to re-read world state.
+type LifecycleEvent struct {
+	Actor    string      `json:"actor"` // MSP ID of the submitting client
+	Key      string      `json:"key"`   // chip ID, escrow txn ID, recall or report ID
+	NewState interface{} `json:"newState"`
+	OldState interface{} `json:"oldState"`
+	TxID     string      `json:"txID"`
+	Type     string      `json:"type"`
+	Version  int         `json:"version"`
+}
+
+// emitEvent sets the transaction's chaincode event.
+func emitEvent(ctx contractapi.TransactionContextInterface, eventType, key string, oldState, newState interface{}) error {
+	actor, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+	payload, err := json.Marshal(LifecycleEvent{
+		Actor:    actor,
+		Key:      key,
+		NewState: newState,
+		OldState: oldState,
+		TxID:     ctx.GetStub().GetTxID(),
+		Type:     eventType,
+		Version:  eventVersion,
+	})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().SetEvent(eventType, payload)
+}
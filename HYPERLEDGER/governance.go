@@ -0,0 +1,411 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// GovernanceContract is the Fabric port of voting.sol (Stakeholders). Existing
+// members propose a new organisation, every active member votes once, and a
+// strict majority of active members admits it.
+type GovernanceContract struct {
+	contractapi.Contract
+}
+
+// Capabilities a stakeholder declares for itself, checked by Init.
+const (
+	CapabilityShip    = "can-ship"
+	CapabilityReceive = "can-receive"
+)
+
+var knownCapabilities = map[string]bool{
+	CapabilityShip:    true,
+	CapabilityReceive: true,
+}
+
+type Stakeholder struct {
+	Active           bool     `json:"active"`
+	Capabilities     []string `json:"capabilities"` // see SetCapabilities
+	Description      string   `json:"description"`
+	InvolvedProducts []string `json:"involvedProducts"`
+	Maker            string   `json:"maker"` // who proposed this stakeholder
+	MSPID            string   `json:"mspID"`
+	Name             string   `json:"name"`
+	Timestamp        int64    `json:"timestamp"` // when it was admitted
+}
+
+type StakeholderProposal struct {
+	Description string   `json:"description"`
+	MSPID       string   `json:"mspID"`
+	Name        string   `json:"name"`
+	Proposer    string   `json:"proposer"`
+	Timestamp   int64    `json:"timestamp"`
+	Votes       []string `json:"votes"` // MSP IDs that approved, proposer included
+}
+
+func txTimestamp(ctx contractapi.TransactionContextInterface) (int64, error) {
+	ts, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read transaction timestamp: %v", err)
+	}
+	return ts.Seconds, nil
+}
+
+func readStakeholder(ctx contractapi.TransactionContextInterface, mspID string) (*Stakeholder, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(stakeholderObjectType, []string{mspID})
+	if err != nil {
+		return nil, err
+	}
+	stakeholderJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if stakeholderJSON == nil {
+		return nil, nil
+	}
+
+	var stakeholder Stakeholder
+	err = json.Unmarshal(stakeholderJSON, &stakeholder)
+	if err != nil {
+		return nil, err
+	}
+	return &stakeholder, nil
+}
+
+func putStakeholder(ctx contractapi.TransactionContextInterface, stakeholder *Stakeholder) error {
+	key, err := ctx.GetStub().CreateCompositeKey(stakeholderObjectType, []string{stakeholder.MSPID})
+	if err != nil {
+		return err
+	}
+	stakeholderJSON, err := json.Marshal(stakeholder)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, stakeholderJSON)
+}
+
+// requireActiveStakeholder fails unless mspID was admitted and is still active.
+func requireActiveStakeholder(ctx contractapi.TransactionContextInterface, mspID string) error {
+	stakeholder, err := readStakeholder(ctx, mspID)
+	if err != nil {
+		return err
+	}
+	if stakeholder == nil {
+		return fmt.Errorf("%s is not an admitted stakeholder", mspID)
+	}
+	if !stakeholder.Active {
+		return fmt.Errorf("stakeholder %s is not active", mspID)
+	}
+	return nil
+}
+
+// requireCapability fails unless mspID is an active stakeholder that declared capability.
+func requireCapability(ctx contractapi.TransactionContextInterface, mspID, capability string) error {
+	if err := requireActiveStakeholder(ctx, mspID); err != nil {
+		return err
+	}
+	stakeholder, err := readStakeholder(ctx, mspID)
+	if err != nil {
+		return err
+	}
+	for _, declared := range stakeholder.Capabilities {
+		if declared == capability {
+			return nil
+		}
+	}
+	return fmt.Errorf("stakeholder %s has not declared %s", mspID, capability)
+}
+
+// callerStakeholder returns the caller's MSP ID after checking it is an active stakeholder.
+func callerStakeholder(ctx contractapi.TransactionContextInterface) (string, error) {
+	x, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get client identity: %v", err)
+	}
+	if err := requireActiveStakeholder(ctx, x); err != nil {
+		return "", fmt.Errorf("user not authorized: %v", err)
+	}
+	return x, nil
+}
+
+// activeStakeholderCount counts members that currently hold voting rights.
+func activeStakeholderCount(ctx contractapi.TransactionContextInterface) (int, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(stakeholderObjectType, []string{})
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	count := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+		var stakeholder Stakeholder
+		err = json.Unmarshal(queryResponse.Value, &stakeholder)
+		if err != nil {
+			return 0, err
+		}
+		if stakeholder.Active {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func readProposal(ctx contractapi.TransactionContextInterface, mspID string) (*StakeholderProposal, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(proposalObjectType, []string{mspID})
+	if err != nil {
+		return nil, err
+	}
+	proposalJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if proposalJSON == nil {
+		return nil, nil
+	}
+
+	var proposal StakeholderProposal
+	err = json.Unmarshal(proposalJSON, &proposal)
+	if err != nil {
+		return nil, err
+	}
+	return &proposal, nil
+}
+
+// tallyProposal admits the proposed org once a strict majority of active
+// members approved, otherwise stores the updated proposal.
+func tallyProposal(ctx contractapi.TransactionContextInterface, proposal *StakeholderProposal) error {
+	key, err := ctx.GetStub().CreateCompositeKey(proposalObjectType, []string{proposal.MSPID})
+	if err != nil {
+		return err
+	}
+	members, err := activeStakeholderCount(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(proposal.Votes)*2 > members {
+		ts, err := txTimestamp(ctx)
+		if err != nil {
+			return err
+		}
+		stakeholder := Stakeholder{
+			Active:           true,
+			Capabilities:     []string{},
+			Description:      proposal.Description,
+			InvolvedProducts: []string{},
+			Maker:            proposal.Proposer,
+			MSPID:            proposal.MSPID,
+			Name:             proposal.Name,
+			Timestamp:        ts,
+		}
+		if err := putStakeholder(ctx, &stakeholder); err != nil {
+			return err
+		}
+		return ctx.GetStub().DelState(key)
+	}
+
+	proposalJSON, err := json.Marshal(proposal)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, proposalJSON)
+}
+
+// ProposeStakeholder opens a vote to admit a new organisation. The proposer's
+// approval is counted straight away. [invoke]
+func (g *GovernanceContract) ProposeStakeholder(ctx contractapi.TransactionContextInterface, mspID, name, description string) error {
+	x, err := callerStakeholder(ctx)
+	if err != nil {
+		return err
+	}
+
+	existing, err := readStakeholder(ctx, mspID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("%s is already a stakeholder", mspID)
+	}
+	open, err := readProposal(ctx, mspID)
+	if err != nil {
+		return err
+	}
+	if open != nil {
+		return fmt.Errorf("a proposal for %s is already open", mspID)
+	}
+
+	ts, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	proposal := StakeholderProposal{
+		Description: description,
+		MSPID:       mspID,
+		Name:        name,
+		Proposer:    x,
+		Timestamp:   ts,
+		Votes:       []string{x},
+	}
+	return tallyProposal(ctx, &proposal)
+}
+
+// VoteToAddStakeholder approves an open proposal. Each member votes once. [invoke]
+func (g *GovernanceContract) VoteToAddStakeholder(ctx contractapi.TransactionContextInterface, mspID string) error {
+	x, err := callerStakeholder(ctx)
+	if err != nil {
+		return err
+	}
+
+	proposal, err := readProposal(ctx, mspID)
+	if err != nil {
+		return err
+	}
+	if proposal == nil {
+		return fmt.Errorf("there is no open proposal for %s", mspID)
+	}
+	for _, voter := range proposal.Votes {
+		if voter == x {
+			return fmt.Errorf("%s has already voted for %s", x, mspID)
+		}
+	}
+
+	proposal.Votes = append(proposal.Votes, x)
+	return tallyProposal(ctx, proposal)
+}
+
+// ChangeStatus activates or deactivates the caller's own stakeholder profile. [invoke]
+func (g *GovernanceContract) ChangeStatus(ctx contractapi.TransactionContextInterface, active bool) error {
+	x, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+	stakeholder, err := readStakeholder(ctx, x)
+	if err != nil {
+		return err
+	}
+	if stakeholder == nil {
+		return fmt.Errorf("%s is not an admitted stakeholder", x)
+	}
+
+	stakeholder.Active = active
+	return putStakeholder(ctx, stakeholder)
+}
+
+// SetCapabilities replaces the capabilities the caller declares, any of
+// can-ship and can-receive. [invoke]
+func (g *GovernanceContract) SetCapabilities(ctx contractapi.TransactionContextInterface, capabilities []string) error {
+	x, err := callerStakeholder(ctx)
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for _, capability := range capabilities {
+		if !knownCapabilities[capability] {
+			return fmt.Errorf("unknown capability %q, expected %s or %s", capability, CapabilityShip, CapabilityReceive)
+		}
+		if seen[capability] {
+			return fmt.Errorf("capability %s is listed twice", capability)
+		}
+		seen[capability] = true
+	}
+	stakeholder, err := readStakeholder(ctx, x)
+	if err != nil {
+		return err
+	}
+
+	stakeholder.Capabilities = append([]string{}, capabilities...)
+	return putStakeholder(ctx, stakeholder)
+}
+
+// AddStakeholderProduct records a product the caller is involved with. [invoke]
+func (g *GovernanceContract) AddStakeholderProduct(ctx contractapi.TransactionContextInterface, productID string) error {
+	x, err := callerStakeholder(ctx)
+	if err != nil {
+		return err
+	}
+	stakeholder, err := readStakeholder(ctx, x)
+	if err != nil {
+		return err
+	}
+
+	stakeholder.InvolvedProducts = append(stakeholder.InvolvedProducts, productID)
+	return putStakeholder(ctx, stakeholder)
+}
+
+// GetStakeholder returns the profile of an admitted organisation. [query]
+func (g *GovernanceContract) GetStakeholder(ctx contractapi.TransactionContextInterface, mspID string) (*Stakeholder, error) {
+	if _, err := callerStakeholder(ctx); err != nil {
+		return nil, err
+	}
+	stakeholder, err := readStakeholder(ctx, mspID)
+	if err != nil {
+		return nil, err
+	}
+	if stakeholder == nil {
+		return nil, fmt.Errorf("%s is not an admitted stakeholder", mspID)
+	}
+	return stakeholder, nil
+}
+
+// GetProposal returns the open admission proposal for mspID. [query]
+func (g *GovernanceContract) GetProposal(ctx contractapi.TransactionContextInterface, mspID string) (*StakeholderProposal, error) {
+	proposal, err := readProposal(ctx, mspID)
+	if err != nil {
+		return nil, err
+	}
+	if proposal == nil {
+		return nil, fmt.Errorf("there is no open proposal for %s", mspID)
+	}
+	return proposal, nil
+}
+
+// GetNumberOfStakeholders returns how many members currently hold voting rights. [query]
+func (g *GovernanceContract) GetNumberOfStakeholders(ctx contractapi.TransactionContextInterface) (int, error) {
+	return activeStakeholderCount(ctx)
+}
+
+// GetStakeholdersWithCapability lists the active stakeholders that declared
+// capability, e.g. the carriers an escrow can name. [query]
+func (g *GovernanceContract) GetStakeholdersWithCapability(ctx contractapi.TransactionContextInterface, capability string) ([]*Stakeholder, error) {
+	if !knownCapabilities[capability] {
+		return nil, fmt.Errorf("unknown capability %q, expected %s or %s", capability, CapabilityShip, CapabilityReceive)
+	}
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(stakeholderObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	stakeholders := []*Stakeholder{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var stakeholder Stakeholder
+		err = json.Unmarshal(queryResponse.Value, &stakeholder)
+		if err != nil {
+			return nil, err
+		}
+		if !stakeholder.Active {
+			continue
+		}
+		for _, declared := range stakeholder.Capabilities {
+			if declared == capability {
+				stakeholders = append(stakeholders, &stakeholder)
+				break
+			}
+		}
+	}
+	return stakeholders, nil
+}
@@ -0,0 +1,67 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import "testing"
+
+// requireStakeholder fails unless mspID is, or is not, an admitted stakeholder.
+func requireStakeholder(t *testing.T, ctx *testContext, mspID string, want bool) {
+	t.Helper()
+	stakeholder, err := readStakeholder(ctx, mspID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (stakeholder != nil) != want {
+		t.Errorf("%s admitted: got %t, want %t", mspID, stakeholder != nil, want)
+	}
+}
+
+func TestProposalNeedsAStrictMajority(t *testing.T) {
+	g := &GovernanceContract{}
+	ctx := newTestContext("Org1MSP")
+	addStakeholders(t, ctx, "Org1MSP", "Org2MSP", "Org3MSP", "Org4MSP")
+
+	if err := g.ProposeStakeholder(ctx, "Org5MSP", "Org5", "assembler"); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+	if err := g.VoteToAddStakeholder(ctx.as("Org2MSP"), "Org5MSP"); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+	requireStakeholder(t, ctx, "Org5MSP", false)
+
+	if err := g.VoteToAddStakeholder(ctx, "Org5MSP"); err == nil {
+		t.Error("Org2MSP voted twice")
+	}
+	if err := g.VoteToAddStakeholder(ctx.as("Org3MSP"), "Org5MSP"); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+	requireStakeholder(t, ctx, "Org5MSP", true)
+	if proposal, err := readProposal(ctx, "Org5MSP"); err != nil || proposal != nil {
+		t.Errorf("proposal: got %v and %v, want it closed", proposal, err)
+	}
+}
+
+func TestInactiveMembersDoNotCountTowardsTheMajority(t *testing.T) {
+	g := &GovernanceContract{}
+	ctx := newTestContext("Org1MSP")
+	addStakeholders(t, ctx, "Org1MSP", "Org2MSP", "Org3MSP")
+	if err := putStakeholder(ctx, &Stakeholder{MSPID: "Org4MSP"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+
+	if err := g.ProposeStakeholder(ctx, "Org5MSP", "Org5", "assembler"); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+	if err := g.VoteToAddStakeholder(ctx.as("Org2MSP"), "Org5MSP"); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+	requireStakeholder(t, ctx, "Org5MSP", true)
+}
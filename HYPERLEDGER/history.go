@@ -0,0 +1,196 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// AssetHistoryEntry is one committed version of an Asset.
+type AssetHistoryEntry struct {
+	Asset     *Asset    `json:"asset"`   // nil when the version is a deletion
+	AssetID   string    `json:"assetID"` // differs from the queried ID for parent and merged lots
+	EscrowID  string    `json:"escrowID"`
+	IsDelete  bool      `json:"isDelete"`
+	Timestamp time.Time `json:"timestamp"`
+	TxID      string    `json:"txID"`
+}
+
+// GetAssetHistory returns every version of an asset, oldest first, so the
+// custody chain from manufacturer to current holder can be shown. It follows
+// the lot's lineage: versions of the lot it was split from, up to the split,
+// and of lots merged into it, up to the merge, are included. [query]
+func (s *SmartContract) GetAssetHistory(ctx contractapi.TransactionContextInterface, id string) ([]AssetHistoryEntry, error) {
+	history, err := assetLineage(ctx, id, "", map[string]bool{})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(history, func(i, j int) bool { return history[i].Timestamp.Before(history[j].Timestamp) })
+	return history, nil
+}
+
+// assetLineage collects the versions of lot id up to and including untilTx
+// ("" for all), then those of its parent and merged lots. seen drops versions
+// reached along two paths, e.g. two halves of a split merged back together.
+func assetLineage(ctx contractapi.TransactionContextInterface, id, untilTx string, seen map[string]bool) ([]AssetHistoryEntry, error) {
+	versions, err := assetVersions(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	for i, entry := range versions {
+		if entry.TxID == untilTx {
+			versions = versions[:i+1]
+			break
+		}
+	}
+
+	var lineage []AssetHistoryEntry
+	if first := versions[0].Asset; first != nil && first.ParentID != "" {
+		parent, err := assetLineage(ctx, first.ParentID, versions[0].TxID, seen)
+		if err != nil {
+			return nil, err
+		}
+		lineage = append(lineage, parent...)
+	}
+	var mergedFrom []string
+	for _, entry := range versions {
+		if entry.Asset != nil {
+			mergedFrom = entry.Asset.MergedFrom
+		}
+	}
+	for _, sourceID := range mergedFrom {
+		source, err := assetLineage(ctx, sourceID, "", seen)
+		if err != nil {
+			return nil, err
+		}
+		lineage = append(lineage, source...)
+	}
+	for _, entry := range versions {
+		if seen[entry.AssetID+"\x00"+entry.TxID] {
+			continue
+		}
+		seen[entry.AssetID+"\x00"+entry.TxID] = true
+		lineage = append(lineage, entry)
+	}
+	return lineage, nil
+}
+
+// keyHistory returns every committed modification of key, oldest first.
+func keyHistory(ctx contractapi.TransactionContextInterface, key string) ([]*queryresult.KeyModification, error) {
+	resultsIterator, err := ctx.GetStub().GetHistoryForKey(key)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var modifications []*queryresult.KeyModification
+	for resultsIterator.HasNext() {
+		modification, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		modifications = append(modifications, modification)
+	}
+	// Fabric 2.x returns history newest first
+	for i, j := 0, len(modifications)-1; i < j; i, j = i+1, j-1 {
+		modifications[i], modifications[j] = modifications[j], modifications[i]
+	}
+	return modifications, nil
+}
+
+// modificationTime converts a history timestamp, zero when the peer gave none.
+func modificationTime(modification *queryresult.KeyModification) time.Time {
+	if modification.Timestamp == nil {
+		return time.Time{}
+	}
+	return time.Unix(modification.Timestamp.Seconds, int64(modification.Timestamp.Nanos)).UTC()
+}
+
+// assetVersions returns the committed versions of a single asset key, oldest first.
+func assetVersions(ctx contractapi.TransactionContextInterface, id string) ([]AssetHistoryEntry, error) {
+	key, err := assetKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	modifications, err := keyHistory(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history of asset %s: %v", id, err)
+	}
+	if len(modifications) == 0 {
+		return nil, fmt.Errorf("the asset %s does not exist", id)
+	}
+
+	var history []AssetHistoryEntry
+	for _, modification := range modifications {
+		entry := AssetHistoryEntry{
+			AssetID:   id,
+			IsDelete:  modification.IsDelete,
+			Timestamp: modificationTime(modification),
+			TxID:      modification.TxId,
+		}
+		if !modification.IsDelete && len(modification.Value) > 0 {
+			var asset Asset
+			err = json.Unmarshal(modification.Value, &asset)
+			if err != nil {
+				return nil, err
+			}
+			entry.Asset = &asset
+			entry.EscrowID = asset.LastEscrow
+		}
+		history = append(history, entry)
+	}
+	return history, nil
+}
+
+// UnitHistoryEntry is one committed version of a Unit.
+type UnitHistoryEntry struct {
+	EscrowID  string    `json:"escrowID"`
+	IsDelete  bool      `json:"isDelete"`
+	Timestamp time.Time `json:"timestamp"`
+	TxID      string    `json:"txID"`
+	Unit      *Unit     `json:"unit"` // nil when the version is a deletion
+}
+
+// GetUnitHistory returns every version of a single unit, oldest first, so a
+// chip can be traced apart from the rest of its lot. [query]
+func (s *SmartContract) GetUnitHistory(ctx contractapi.TransactionContextInterface, serial string) ([]UnitHistoryEntry, error) {
+	key, err := unitKey(ctx, serial)
+	if err != nil {
+		return nil, err
+	}
+	modifications, err := keyHistory(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history of unit %s: %v", serial, err)
+	}
+	if len(modifications) == 0 {
+		return nil, fmt.Errorf("the unit %s does not exist", serial)
+	}
+
+	var history []UnitHistoryEntry
+	for _, modification := range modifications {
+		entry := UnitHistoryEntry{
+			IsDelete:  modification.IsDelete,
+			Timestamp: modificationTime(modification),
+			TxID:      modification.TxId,
+		}
+		if !modification.IsDelete && len(modification.Value) > 0 {
+			var unit Unit
+			err = json.Unmarshal(modification.Value, &unit)
+			if err != nil {
+				return nil, err
+			}
+			entry.Unit = &unit
+			entry.EscrowID = unit.LastEscrow
+		}
+		history = append(history, entry)
+	}
+	return history, nil
+}
@@ -0,0 +1,83 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Every record type lives under its own composite-key object type so that a
+// chip ID can never collide with an escrow ID or a config record, and so that
+// partial-key scans only ever return one type. Plain range queries skip
+// composite keys, which is how MigrateLegacyKeys finds records written before
+// this layout.
+const (
+	assetObjectType        = "asset"
+	balanceObjectType      = "balance"
+	configObjectType       = "config"
+	disputeObjectType      = "dispute"
+	escrowObjectType       = "escrow"
+	lockObjectType         = "lock"
+	manufacturerObjectType = "manufacturer"
+	proposalObjectType     = "proposal"
+	recallObjectType       = "recall"
+	reportObjectType       = "report"
+	reputationObjectType   = "reputation"
+	stakeholderObjectType  = "stakeholder"
+	unitObjectType         = "unit"
+)
+
+// Secondary indexes over assets. Each index entry is an empty-valued composite
+// key whose last attribute is the chip ID, so a partial-key scan on the first
+// attribute lists matching assets without touching the asset records.
+const (
+	ownerIndex        = "owner~id"
+	chipNameIndex     = "chipName~id"
+	manufacturerIndex = "manufacturer~id"
+)
+
+// lotIndex lists the units registered under a lot, last attribute is the serial.
+const lotIndex = "lot~serial"
+
+// escrowAssetIndex lists the escrows drafted on a lot, last attribute is the txn ID.
+const escrowAssetIndex = "asset~escrow"
+
+// Counterfeit report indexes, last attribute is the report ID.
+const (
+	reportTargetIndex = "target~report"
+	reporterIndex     = "reporter~report"
+)
+
+// legacyManufacturerKey is the simple key the original single Check record used.
+const legacyManufacturerKey = "1"
+
+func assetKey(ctx contractapi.TransactionContextInterface, id string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(assetObjectType, []string{id})
+}
+
+func unitKey(ctx contractapi.TransactionContextInterface, serial string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(unitObjectType, []string{serial})
+}
+
+func escrowKey(ctx contractapi.TransactionContextInterface, txn string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(escrowObjectType, []string{txn})
+}
+
+// assetIndexKeys returns the secondary index entries an asset should have.
+func assetIndexKeys(ctx contractapi.TransactionContextInterface, asset *Asset) ([]string, error) {
+	var keys []string
+	for _, index := range []struct{ name, value string }{
+		{ownerIndex, asset.Owner},
+		{chipNameIndex, asset.ChipName},
+		{manufacturerIndex, asset.Manufacturer},
+	} {
+		key, err := ctx.GetStub().CreateCompositeKey(index.name, []string{index.value, asset.ChipID})
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
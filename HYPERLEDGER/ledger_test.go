@@ -0,0 +1,194 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// ledgerStub behaves like the peer for the calls the chaincode makes: reads
+// see the committed state only, writes become visible after commit.
+type ledgerStub struct {
+	shim.ChaincodeStubInterface
+	committed map[string][]byte
+	now       int64             // transaction timestamp, unix seconds
+	private   map[string][]byte // private data by collection and key
+	transient map[string][]byte // transient map of the next transaction
+	writes    map[string][]byte
+}
+
+func newLedgerStub() *ledgerStub {
+	return &ledgerStub{committed: map[string][]byte{}, private: map[string][]byte{}, writes: map[string][]byte{}}
+}
+
+func (l *ledgerStub) commit() {
+	for key, value := range l.writes {
+		if value == nil {
+			delete(l.committed, key)
+		} else {
+			l.committed[key] = value
+		}
+	}
+	l.writes = map[string][]byte{}
+}
+
+func (l *ledgerStub) GetTxID() string { return "tx1" }
+
+func (l *ledgerStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return &timestamp.Timestamp{Seconds: l.now}, nil
+}
+
+func (l *ledgerStub) GetState(key string) ([]byte, error) { return l.committed[key], nil }
+
+func (l *ledgerStub) PutState(key string, value []byte) error {
+	l.writes[key] = value
+	return nil
+}
+
+func (l *ledgerStub) DelState(key string) error {
+	l.writes[key] = nil
+	return nil
+}
+
+func (l *ledgerStub) GetPrivateData(collection, key string) ([]byte, error) {
+	return l.private[collection+"/"+key], nil
+}
+
+func (l *ledgerStub) PutPrivateData(collection, key string, value []byte) error {
+	l.private[collection+"/"+key] = value
+	return nil
+}
+
+func (l *ledgerStub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	return "\x00" + objectType + "\x00" + strings.Join(attributes, "\x00") + "\x00", nil
+}
+
+func (l *ledgerStub) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	parts := strings.Split(strings.Trim(compositeKey, "\x00"), "\x00")
+	return parts[0], parts[1:], nil
+}
+
+func (l *ledgerStub) GetStateByPartialCompositeKey(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	prefix := "\x00" + objectType + "\x00"
+	for _, attribute := range attributes {
+		prefix += attribute + "\x00"
+	}
+	return l.scan(func(key string) bool { return strings.HasPrefix(key, prefix) }), nil
+}
+
+// GetStateByRange skips composite keys like the peer does; "" leaves a bound open.
+func (l *ledgerStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	return l.scan(func(key string) bool {
+		return !strings.HasPrefix(key, "\x00") && key >= startKey && (endKey == "" || key < endKey)
+	}), nil
+}
+
+// scan iterates the committed keys that match, in key order.
+func (l *ledgerStub) scan(match func(key string) bool) *kvIterator {
+	var keys []string
+	for key := range l.committed {
+		if match(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	iterator := &kvIterator{}
+	for _, key := range keys {
+		iterator.kvs = append(iterator.kvs, &queryresult.KV{Key: key, Value: l.committed[key]})
+	}
+	return iterator
+}
+
+func (l *ledgerStub) GetTransient() (map[string][]byte, error) { return l.transient, nil }
+
+func (l *ledgerStub) SetEvent(name string, payload []byte) error { return nil }
+
+type kvIterator struct {
+	kvs []*queryresult.KV
+}
+
+func (i *kvIterator) HasNext() bool { return len(i.kvs) > 0 }
+
+func (i *kvIterator) Close() error { return nil }
+
+func (i *kvIterator) Next() (*queryresult.KV, error) {
+	kv := i.kvs[0]
+	i.kvs = i.kvs[1:]
+	return kv, nil
+}
+
+type mspIdentity struct {
+	cid.ClientIdentity
+	mspID string
+}
+
+func (m *mspIdentity) GetMSPID() (string, error) { return m.mspID, nil }
+
+type testContext struct {
+	stub     *ledgerStub
+	identity *mspIdentity
+}
+
+func (c *testContext) GetStub() shim.ChaincodeStubInterface { return c.stub }
+
+func (c *testContext) GetClientIdentity() cid.ClientIdentity { return c.identity }
+
+var _ contractapi.TransactionContextInterface = (*testContext)(nil)
+
+func newTestContext(mspID string) *testContext {
+	return &testContext{stub: newLedgerStub(), identity: &mspIdentity{mspID: mspID}}
+}
+
+// as switches the calling org for the next transaction.
+func (c *testContext) as(mspID string) *testContext {
+	c.identity = &mspIdentity{mspID: mspID}
+	return c
+}
+
+func requireBalance(t *testing.T, s *SmartContract, ctx *testContext, account string, want uint64) {
+	t.Helper()
+	got, err := s.BalanceOf(ctx, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("balance of %s: got %d, want %d", account, got, want)
+	}
+}
+
+func requireEscrowStatus(t *testing.T, s *SmartContract, ctx *testContext, txn string, want EscrowStatus) {
+	t.Helper()
+	escrow, err := s.ReadEscrow(ctx, txn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if escrow.Status != want {
+		t.Errorf("status of %s: got %s, want %s", txn, escrow.Status, want)
+	}
+}
+
+// addStakeholders admits active stakeholders with both capabilities.
+func addStakeholders(t *testing.T, ctx *testContext, mspIDs ...string) {
+	t.Helper()
+	for _, mspID := range mspIDs {
+		stakeholder := &Stakeholder{
+			Active:       true,
+			Capabilities: []string{CapabilityShip, CapabilityReceive},
+			MSPID:        mspID,
+		}
+		if err := putStakeholder(ctx, stakeholder); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx.stub.commit()
+}
@@ -0,0 +1,155 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// An escrow locks what it sells from Init until it reaches a final status, so
+// the same chips cannot be promised to two receivers or moved away while the
+// escrow runs. A whole-lot escrow locks the lot, an escrow naming serials
+// locks only those units. recordEscrow releases the locks on every path that
+// closes an escrow: settlement, cancellation and timeouts.
+
+type AssetLock struct {
+	AssetID  string `json:"assetID"`
+	EscrowID string `json:"escrowID"`
+	LockedAt int64  `json:"lockedAt"`
+	Serial   string `json:"serial"` // "" when the whole lot is locked
+}
+
+func assetLockKey(ctx contractapi.TransactionContextInterface, assetID, serial string) (string, error) {
+	if serial == "" {
+		return ctx.GetStub().CreateCompositeKey(lockObjectType, []string{assetID})
+	}
+	return ctx.GetStub().CreateCompositeKey(lockObjectType, []string{assetID, serial})
+}
+
+// assetLocks returns the lot lock and every unit lock under a lot.
+func assetLocks(ctx contractapi.TransactionContextInterface, assetID string) ([]*AssetLock, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(lockObjectType, []string{assetID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	locks := []*AssetLock{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var lock AssetLock
+		err = json.Unmarshal(queryResponse.Value, &lock)
+		if err != nil {
+			return nil, err
+		}
+		locks = append(locks, &lock)
+	}
+	return locks, nil
+}
+
+// requireUnlocked fails while an escrow holds the lot, or, when serials are
+// given, the lot as a whole or one of those units. Without serials any lock
+// under the lot counts.
+func requireUnlocked(ctx contractapi.TransactionContextInterface, assetID string, serials []string) error {
+	locks, err := assetLocks(ctx, assetID)
+	if err != nil {
+		return err
+	}
+	for _, lock := range locks {
+		if lock.Serial == "" || len(serials) == 0 {
+			return fmt.Errorf("asset %s is locked by escrow %s", assetID, lock.EscrowID)
+		}
+		for _, serial := range serials {
+			if lock.Serial == serial {
+				return fmt.Errorf("unit %s is locked by escrow %s", serial, lock.EscrowID)
+			}
+		}
+	}
+	return nil
+}
+
+// acquireAssetLocks locks what a new escrow sells: its lot or named units,
+// or every lot of an order.
+func acquireAssetLocks(ctx contractapi.TransactionContextInterface, escrow *EscrowContract) error {
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	serials := escrow.Serials
+	if len(serials) == 0 {
+		serials = []string{""}
+	}
+	for _, assetID := range escrow.assetIDs() {
+		if err := requireUnlocked(ctx, assetID, escrow.Serials); err != nil {
+			return err
+		}
+		for _, serial := range serials {
+			key, err := assetLockKey(ctx, assetID, serial)
+			if err != nil {
+				return err
+			}
+			lockJSON, err := json.Marshal(AssetLock{
+				AssetID:  assetID,
+				EscrowID: escrow.TxnID,
+				LockedAt: now,
+				Serial:   serial,
+			})
+			if err != nil {
+				return err
+			}
+			if err := ctx.GetStub().PutState(key, lockJSON); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// releaseAssetLocks drops every lock the escrow holds.
+func releaseAssetLocks(ctx contractapi.TransactionContextInterface, escrow *EscrowContract) error {
+	for _, assetID := range escrow.assetIDs() {
+		if err := releaseLocksOn(ctx, escrow.TxnID, assetID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseLocksOn drops the locks escrowID holds on one lot. Locks taken by
+// another escrow are left alone.
+func releaseLocksOn(ctx contractapi.TransactionContextInterface, escrowID, assetID string) error {
+	locks, err := assetLocks(ctx, assetID)
+	if err != nil {
+		return err
+	}
+	for _, lock := range locks {
+		if lock.EscrowID != escrowID {
+			continue
+		}
+		key, err := assetLockKey(ctx, lock.AssetID, lock.Serial)
+		if err != nil {
+			return err
+		}
+		if err := ctx.GetStub().DelState(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAssetLock returns the locks escrows currently hold on a lot or its
+// units, empty when it is free. [query]
+func (s *SmartContract) GetAssetLock(ctx contractapi.TransactionContextInterface, assetID string) ([]*AssetLock, error) {
+	if _, err := s.ReadAsset(ctx, assetID); err != nil {
+		return nil, err
+	}
+	return assetLocks(ctx, assetID)
+}
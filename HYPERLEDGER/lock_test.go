@@ -0,0 +1,52 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import "testing"
+
+func TestUnitLocksLeaveOtherUnitsFree(t *testing.T) {
+	ctx := newTestContext("Org1MSP")
+	first := &EscrowContract{AssetID: "lot1", Serials: []string{"s1"}, TxnID: "e1"}
+	if err := acquireAssetLocks(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+
+	if err := acquireAssetLocks(ctx, &EscrowContract{AssetID: "lot1", Serials: []string{"s1"}, TxnID: "e2"}); err == nil {
+		t.Error("a locked unit was sold twice")
+	}
+	if err := acquireAssetLocks(ctx, &EscrowContract{AssetID: "lot1", TxnID: "e2"}); err == nil {
+		t.Error("the lot was sold while one of its units was locked")
+	}
+	if err := acquireAssetLocks(ctx, &EscrowContract{AssetID: "lot1", Serials: []string{"s2"}, TxnID: "e2"}); err != nil {
+		t.Errorf("a free unit could not be sold: %v", err)
+	}
+}
+
+func TestClosingAnEscrowReleasesOnlyItsLocks(t *testing.T) {
+	s := &SmartContract{}
+	ctx := newTestContext("Org1MSP")
+	first := &EscrowContract{AssetID: "lot1", Serials: []string{"s1"}, Status: StatusFunded, TxnID: "e1"}
+	second := &EscrowContract{AssetID: "lot1", Serials: []string{"s2"}, Status: StatusFunded, TxnID: "e2"}
+	for _, escrow := range []*EscrowContract{first, second} {
+		if err := acquireAssetLocks(ctx, escrow); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx.stub.commit()
+
+	first.Status = StatusCancelled
+	if err := s.recordEscrow(ctx, EventEscrowCancelled, nil, first); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+
+	if err := requireUnlocked(ctx, "lot1", []string{"s1"}); err != nil {
+		t.Errorf("s1 is still locked after its escrow closed: %v", err)
+	}
+	if err := requireUnlocked(ctx, "lot1", []string{"s2"}); err == nil {
+		t.Error("s2 was released by another escrow")
+	}
+}
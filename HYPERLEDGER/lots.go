@@ -0,0 +1,175 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Lots can be split and merged without minting or destroying chips. A split
+// child keeps the parent's chip name, manufacturer and verification hash and
+// points back at it through ParentID; a merge folds one lot into another of
+// the same origin and records it in MergedFrom. GetAssetHistory follows both
+// links.
+
+// deleteAsset removes an asset and its index entries.
+func (s *SmartContract) deleteAsset(ctx contractapi.TransactionContextInterface, asset *Asset) error {
+	indexKeys, err := assetIndexKeys(ctx, asset)
+	if err != nil {
+		return err
+	}
+	for _, indexKey := range indexKeys {
+		if err := ctx.GetStub().DelState(indexKey); err != nil {
+			return err
+		}
+	}
+	key, err := assetKey(ctx, asset.ChipID)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().DelState(key)
+}
+
+// childLot returns a new lot of quantity chips carved out of parent, held by
+// the parent's owner. The caller reduces the parent's quantity.
+func childLot(parent *Asset, id string, quantity uint64) Asset {
+	return Asset{
+		ChipID:       id,
+		ChipName:     parent.ChipName,
+		Components:   parent.Components,
+		Manufacturer: parent.Manufacturer,
+		MergedFrom:   []string{},
+		Owner:        parent.Owner,
+		ParentID:     parent.ChipID,
+		Quantity:     quantity,
+		VerifyHash:   parent.VerifyHash,
+		VerifySalt:   parent.VerifySalt,
+	}
+}
+
+// SplitAsset carves quantity chips out of lot id into a new lot newID held by
+// the same owner. serials names the registered units that go with the new
+// lot; each lot must still have room for the units left under it. [invoke]
+func (s *SmartContract) SplitAsset(ctx contractapi.TransactionContextInterface, id, newID string, quantity uint64, serials []string) error {
+	asset, err := s.ReadAsset(ctx, id)
+	if err != nil {
+		return err
+	}
+	x, err := requireCaller(ctx, asset.Owner, "the asset owner")
+	if err != nil {
+		return err
+	}
+	if err := requireTradableLot(ctx, asset); err != nil {
+		return err
+	}
+	if err := requireUnlocked(ctx, id, nil); err != nil {
+		return err
+	}
+	if asset.Quantity < 2 {
+		return fmt.Errorf("lot %s holds %d chips and cannot be split", id, asset.Quantity)
+	}
+	if quantity == 0 || quantity >= asset.Quantity {
+		return fmt.Errorf("split quantity must be between 1 and %d", asset.Quantity-1)
+	}
+	exists, err := s.AssetExists(ctx, newID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("the asset %s already exists", newID)
+	}
+	moving, err := ownedUnits(ctx, id, serials, x)
+	if err != nil {
+		return err
+	}
+	registered, err := lotUnits(ctx, id)
+	if err != nil {
+		return err
+	}
+	if uint64(len(moving)) > quantity {
+		return fmt.Errorf("%d serials do not fit in a lot of %d", len(moving), quantity)
+	}
+	if uint64(len(registered)-len(moving)) > asset.Quantity-quantity {
+		return fmt.Errorf("lot %s would keep %d units for %d chips, move more serials", id, len(registered)-len(moving), asset.Quantity-quantity)
+	}
+
+	old := *asset
+	asset.Quantity -= quantity
+	child := childLot(asset, newID, quantity)
+	if err := s.putAsset(ctx, asset); err != nil {
+		return err
+	}
+	if err := s.putAsset(ctx, &child); err != nil {
+		return err
+	}
+	for _, unit := range moving {
+		if err := relotUnit(ctx, unit, newID); err != nil {
+			return err
+		}
+	}
+
+	return emitEvent(ctx, EventAssetSplit, id, &old, []*Asset{asset, &child})
+}
+
+// MergeAssets folds lot sourceID into lot targetID. Both must be held by the
+// caller and come from the same minted lot. The source lot is removed and its
+// units move to the target, which lists it and the lots it had absorbed in
+// MergedFrom. [invoke]
+func (s *SmartContract) MergeAssets(ctx contractapi.TransactionContextInterface, targetID, sourceID string) error {
+	if targetID == sourceID {
+		return fmt.Errorf("cannot merge lot %s into itself", targetID)
+	}
+	target, err := s.ReadAsset(ctx, targetID)
+	if err != nil {
+		return err
+	}
+	source, err := s.ReadAsset(ctx, sourceID)
+	if err != nil {
+		return err
+	}
+	if _, err := requireCaller(ctx, target.Owner, "the asset owner"); err != nil {
+		return err
+	}
+	for _, lot := range []*Asset{target, source} {
+		if err := requireTradableLot(ctx, lot); err != nil {
+			return err
+		}
+		if err := requireUnlocked(ctx, lot.ChipID, nil); err != nil {
+			return err
+		}
+	}
+	if source.Owner != target.Owner {
+		return fmt.Errorf("lots %s and %s have different owners", targetID, sourceID)
+	}
+	if source.VerifyHash != target.VerifyHash || source.ChipName != target.ChipName || source.Manufacturer != target.Manufacturer {
+		return fmt.Errorf("lots %s and %s do not come from the same minted lot", targetID, sourceID)
+	}
+	if target.Quantity+source.Quantity < target.Quantity {
+		return fmt.Errorf("merged quantity overflows")
+	}
+	units, err := lotUnits(ctx, sourceID)
+	if err != nil {
+		return err
+	}
+
+	old := []Asset{*target, *source}
+	target.Quantity += source.Quantity
+	target.MergedFrom = append(append(target.MergedFrom, sourceID), source.MergedFrom...)
+	if err := s.putAsset(ctx, target); err != nil {
+		return err
+	}
+	if err := s.deleteAsset(ctx, source); err != nil {
+		return err
+	}
+	for _, unit := range units {
+		if err := relotUnit(ctx, unit, targetID); err != nil {
+			return err
+		}
+	}
+
+	return emitEvent(ctx, EventAssetsMerged, targetID, old, target)
+}
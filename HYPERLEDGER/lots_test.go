@@ -0,0 +1,31 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitRejectsLotsTooSmallToSplit(t *testing.T) {
+	s := &SmartContract{}
+	ctx := newTestContext("Org1MSP")
+	for _, lot := range []*Asset{
+		{ChipID: "empty", DocType: "asset", Owner: "Org1MSP", Quantity: 0},
+		{ChipID: "single", DocType: "asset", Owner: "Org1MSP", Quantity: 1},
+	} {
+		if err := s.putAsset(ctx, lot); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx.stub.commit()
+
+	for _, id := range []string{"empty", "single"} {
+		err := s.SplitAsset(ctx, id, id+"-part", 1, nil)
+		if err == nil || !strings.Contains(err.Error(), "cannot be split") {
+			t.Errorf("splitting %s: got %v, want a lot too small to split", id, err)
+		}
+	}
+}
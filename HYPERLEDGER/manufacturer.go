@@ -0,0 +1,187 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	ManufacturerActive    = "ACTIVE"
+	ManufacturerSuspended = "SUSPENDED"
+)
+
+// Manufacturer is an org allowed to mint assets. Replaces the single Check "1" record.
+type Manufacturer struct {
+	AddedBy string `json:"addedBy"`
+	MSPID   string `json:"mspID"`
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+}
+
+func readManufacturer(ctx contractapi.TransactionContextInterface, mspID string) (*Manufacturer, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(manufacturerObjectType, []string{mspID})
+	if err != nil {
+		return nil, err
+	}
+	manufacturerJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if manufacturerJSON == nil {
+		return nil, nil
+	}
+
+	var manufacturer Manufacturer
+	err = json.Unmarshal(manufacturerJSON, &manufacturer)
+	if err != nil {
+		return nil, err
+	}
+	return &manufacturer, nil
+}
+
+func putManufacturer(ctx contractapi.TransactionContextInterface, manufacturer *Manufacturer) error {
+	key, err := ctx.GetStub().CreateCompositeKey(manufacturerObjectType, []string{manufacturer.MSPID})
+	if err != nil {
+		return err
+	}
+	manufacturerJSON, err := json.Marshal(manufacturer)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, manufacturerJSON)
+}
+
+// requireActiveManufacturer fails unless mspID is registered and not suspended.
+func requireActiveManufacturer(ctx contractapi.TransactionContextInterface, mspID string) error {
+	manufacturer, err := readManufacturer(ctx, mspID)
+	if err != nil {
+		return err
+	}
+	if manufacturer == nil {
+		return fmt.Errorf("this entity is not a manufacturer and cannot create assets")
+	}
+	if manufacturer.Status != ManufacturerActive {
+		return fmt.Errorf("manufacturer %s is %s and cannot create assets", mspID, manufacturer.Status)
+	}
+	return nil
+}
+
+// AddManufacturer registers an admitted stakeholder as a manufacturer. Admin only. [invoke]
+func (s *SmartContract) AddManufacturer(ctx contractapi.TransactionContextInterface, mspID, name string) error {
+	admin, err := requireAdmin(ctx)
+	if err != nil {
+		return err
+	}
+	if err := requireActiveStakeholder(ctx, mspID); err != nil {
+		return err
+	}
+	existing, err := readManufacturer(ctx, mspID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("manufacturer %s is already registered", mspID)
+	}
+
+	return putManufacturer(ctx, &Manufacturer{
+		AddedBy: admin,
+		MSPID:   mspID,
+		Name:    name,
+		Status:  ManufacturerActive,
+	})
+}
+
+// setManufacturerStatus changes the status of a registered manufacturer. Admin only.
+func setManufacturerStatus(ctx contractapi.TransactionContextInterface, mspID, status string) error {
+	if _, err := requireAdmin(ctx); err != nil {
+		return err
+	}
+	manufacturer, err := readManufacturer(ctx, mspID)
+	if err != nil {
+		return err
+	}
+	if manufacturer == nil {
+		return fmt.Errorf("manufacturer %s is not registered", mspID)
+	}
+	if manufacturer.Status == status {
+		return fmt.Errorf("manufacturer %s is already %s", mspID, status)
+	}
+
+	manufacturer.Status = status
+	return putManufacturer(ctx, manufacturer)
+}
+
+// SuspendManufacturer stops a manufacturer from creating assets. Admin only. [invoke]
+func (s *SmartContract) SuspendManufacturer(ctx contractapi.TransactionContextInterface, mspID string) error {
+	return setManufacturerStatus(ctx, mspID, ManufacturerSuspended)
+}
+
+// ReinstateManufacturer lifts a suspension. Admin only. [invoke]
+func (s *SmartContract) ReinstateManufacturer(ctx contractapi.TransactionContextInterface, mspID string) error {
+	return setManufacturerStatus(ctx, mspID, ManufacturerActive)
+}
+
+// RemoveManufacturer deletes a manufacturer from the registry. Assets it
+// already minted keep their Manufacturer field. Admin only. [invoke]
+func (s *SmartContract) RemoveManufacturer(ctx contractapi.TransactionContextInterface, mspID string) error {
+	if _, err := requireAdmin(ctx); err != nil {
+		return err
+	}
+	manufacturer, err := readManufacturer(ctx, mspID)
+	if err != nil {
+		return err
+	}
+	if manufacturer == nil {
+		return fmt.Errorf("manufacturer %s is not registered", mspID)
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(manufacturerObjectType, []string{mspID})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().DelState(key)
+}
+
+// GetManufacturer returns a registered manufacturer. [query]
+func (s *SmartContract) GetManufacturer(ctx contractapi.TransactionContextInterface, mspID string) (*Manufacturer, error) {
+	manufacturer, err := readManufacturer(ctx, mspID)
+	if err != nil {
+		return nil, err
+	}
+	if manufacturer == nil {
+		return nil, fmt.Errorf("manufacturer %s is not registered", mspID)
+	}
+	return manufacturer, nil
+}
+
+// GetAllManufacturers returns every registered manufacturer. [query]
+func (s *SmartContract) GetAllManufacturers(ctx contractapi.TransactionContextInterface) ([]*Manufacturer, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(manufacturerObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var manufacturers []*Manufacturer
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var manufacturer Manufacturer
+		err = json.Unmarshal(queryResponse.Value, &manufacturer)
+		if err != nil {
+			return nil, err
+		}
+		manufacturers = append(manufacturers, &manufacturer)
+	}
+
+	return manufacturers, nil
+}
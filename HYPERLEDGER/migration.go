@@ -0,0 +1,184 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// legacyEscrow is the EscrowContract layout before the status machine. Some of
+// its json tags were malformed, so those fields were stored under the Go field name.
+type legacyEscrow struct {
+	AssetID              string `json:"AssetID"`
+	ConfirmDelivery      bool   `json:"ConfirmDelivery"`
+	Delivery             string `json:"delivery"`
+	DeliveryStake        uint64 `json:"deliveryStake"`
+	DisputeFlag          bool   `json:"disputeFlag"`
+	EscrowAmount         uint64 `json:"escrowAmount"`
+	InitiateDelivery     bool   `json:"InitiateDelivery"`
+	Receiver             string `json:"receiver"`
+	Sender               string `json:"sender"`
+	StartDelivery        bool   `json:"StartDelivery"`
+	TransactionCompleted bool   `json:"transactionCompleted"`
+	TxnID                string `json:"TxnID"`
+	Verify               string `json:"value"`
+}
+
+// status derives the closest EscrowStatus from the old boolean flags.
+func (l *legacyEscrow) status() EscrowStatus {
+	switch {
+	case l.TransactionCompleted:
+		return StatusVerified
+	case l.DisputeFlag:
+		return StatusDisputed
+	case l.ConfirmDelivery:
+		return StatusDelivered
+	case l.InitiateDelivery:
+		return StatusHandedToCarrier
+	case l.StartDelivery:
+		return StatusFunded
+	default:
+		return StatusDrafted
+	}
+}
+
+// MigrationResult reports what MigrateLegacyKeys moved.
+type MigrationResult struct {
+	Assets   int      `json:"assets"`
+	Escrows  int      `json:"escrows"`
+	Removed  []string `json:"removed"`  // legacy records dropped without a copy
+	Skipped  []string `json:"skipped"`  // keys left untouched, unknown shape
+	Unlocked []string `json:"unlocked"` // open escrows whose asset an earlier migrated escrow locked
+}
+
+// legacyAsset carries the cleartext verification value old assets stored publicly.
+type legacyAsset struct {
+	Asset
+	Value string `json:"value"`
+}
+
+// MigrateLegacyKeys moves records written under simple keys (raw chip ID, raw
+// txn ID, "1") into their typed composite keys. Range queries never return
+// composite keys, so anything the scan finds is legacy. Cleartext verification
+// values are replaced by hashes salted with this transaction's ID, so legacy
+// assets and the escrows that reference them still compare equal. Open
+// escrows get their stage deadlines counted from this transaction and lock
+// their asset like a fresh Init. The old values remain in block history and
+// should be treated as exposed. Admin only. [invoke]
+func (s *SmartContract) MigrateLegacyKeys(ctx contractapi.TransactionContextInterface) (*MigrationResult, error) {
+	if _, err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	salt := ctx.GetStub().GetTxID()
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// locks written here are invisible to requireUnlocked until commit
+	locked := map[string]bool{}
+	result := &MigrationResult{Removed: []string{}, Skipped: []string{}, Unlocked: []string{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		key := queryResponse.Key
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(queryResponse.Value, &fields); err != nil {
+			result.Skipped = append(result.Skipped, key)
+			continue
+		}
+
+		switch {
+		case key == legacyManufacturerKey && fields["pack"] != nil:
+			// InitLedger already carried the manufacturer into the registry
+			result.Removed = append(result.Removed, key)
+
+		case fields["receiver"] != nil && fields["delivery"] != nil:
+			var legacy legacyEscrow
+			if err := json.Unmarshal(queryResponse.Value, &legacy); err != nil {
+				return nil, err
+			}
+			escrow := EscrowContract{
+				AssetID:       legacy.AssetID,
+				Delivery:      legacy.Delivery,
+				DeliveryStake: legacy.DeliveryStake,
+				EscrowAmount:  legacy.EscrowAmount,
+				Receiver:      legacy.Receiver,
+				Sender:        legacy.Sender,
+				Status:        legacy.status(),
+				TxnID:         key,
+				// the old sender value was public already, treat it as revealed
+				SenderReveal: hashVerifyValue(salt, legacy.Verify),
+			}
+			if !escrow.Status.IsFinal() {
+				escrow.Deadlines = newDeadlines(now)
+				if locked[escrow.AssetID] {
+					result.Unlocked = append(result.Unlocked, key)
+				} else {
+					if err := acquireAssetLocks(ctx, &escrow); err != nil {
+						return nil, err
+					}
+					locked[escrow.AssetID] = true
+				}
+			}
+			if err := s.putEscrow(ctx, &escrow); err != nil {
+				return nil, err
+			}
+			if escrow.Status == StatusDisputed {
+				// old disputes never had a record, open one so they can be ruled on
+				if err := openDispute(ctx, &escrow); err != nil {
+					return nil, err
+				}
+			}
+			result.Escrows++
+
+		case fields["chipName"] != nil:
+			var legacy legacyAsset
+			if err := json.Unmarshal(queryResponse.Value, &legacy); err != nil {
+				return nil, err
+			}
+			asset := legacy.Asset
+			asset.VerifyHash = hashVerifyValue(salt, legacy.Value)
+			asset.VerifySalt = salt
+			if asset.ChipID != key {
+				// copy the old VerifyProduct wrote under the escrow's txn ID
+				result.Removed = append(result.Removed, key)
+				break
+			}
+			if asset.Manufacturer == "" {
+				asset.Manufacturer, err = getAdmin(ctx)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if err := s.putAsset(ctx, &asset); err != nil {
+				return nil, err
+			}
+			result.Assets++
+
+		default:
+			result.Skipped = append(result.Skipped, key)
+			continue
+		}
+
+		if err := ctx.GetStub().DelState(key); err != nil {
+			return nil, fmt.Errorf("failed to delete legacy key %s: %v", key, err)
+		}
+	}
+
+	return result, nil
+}
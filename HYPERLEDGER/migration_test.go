@@ -0,0 +1,46 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMigratedOpenEscrowsGetDeadlinesAndLocks(t *testing.T) {
+	s := &SmartContract{}
+	ctx := newTestContext("Org1MSP")
+	ctx.stub.now = 1000
+	if err := putConfig(ctx, &Config{Admin: "Org1MSP"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.committed["txn1"] = []byte(`{"AssetID":"chip1","StartDelivery":true,"delivery":"Org3MSP","receiver":"Org2MSP","sender":"Org1MSP"}`)
+	ctx.stub.committed["txn2"] = []byte(`{"AssetID":"chip1","delivery":"Org3MSP","receiver":"Org4MSP","sender":"Org1MSP"}`)
+	ctx.stub.committed["txn3"] = []byte(`{"AssetID":"chip2","delivery":"Org3MSP","receiver":"Org2MSP","sender":"Org1MSP","transactionCompleted":true}`)
+	ctx.stub.commit()
+
+	result, err := s.MigrateLegacyKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+
+	if result.Escrows != 3 || !reflect.DeepEqual(result.Unlocked, []string{"txn2"}) {
+		t.Errorf("result: got %d escrows and unlocked %v, want 3 and [txn2]", result.Escrows, result.Unlocked)
+	}
+	escrow, err := s.ReadEscrow(ctx, "txn1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if escrow.Status != StatusFunded || escrow.Deadlines != newDeadlines(1000) {
+		t.Errorf("txn1: got %s with %+v, want FUNDED with deadlines from the migration", escrow.Status, escrow.Deadlines)
+	}
+	if err := requireUnlocked(ctx, "chip1", nil); err == nil {
+		t.Error("chip1 is not locked by its open escrow")
+	}
+	if err := requireUnlocked(ctx, "chip2", nil); err != nil {
+		t.Errorf("chip2 is locked by a completed escrow: %v", err)
+	}
+}
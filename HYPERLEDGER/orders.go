@@ -0,0 +1,440 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/bits"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// A purchase order covers several lots in one escrow. InitOrder drafts it
+// with one OrderLine per lot, and the usual steps then work per line:
+// FundOrderLines lets B pay for some lines only, ConfirmOrderLines lets D
+// report which lines arrived, and verification compares every delivered line
+// on its own. Lines that fail settle on their own (undelivered lines are
+// refunded, mismatched lines go to the dispute) while the good lines complete.
+// StartDelivery and ConfirmDelivery with decision=true act on every line.
+//
+// For an order both reveals are a JSON object of verifyRef -> value.
+
+// LineStatus is the state of one OrderLine.
+type LineStatus string
+
+const (
+	LinePending     LineStatus = "PENDING"     // drafted, waiting for B
+	LineFunded      LineStatus = "FUNDED"      // B paid for it
+	LineRejected    LineStatus = "REJECTED"    // B did not pay for it
+	LineDelivered   LineStatus = "DELIVERED"   // D dropped it off, waiting for verification
+	LineUndelivered LineStatus = "UNDELIVERED" // D did not deliver it, B refunded
+	LineCompleted   LineStatus = "COMPLETED"   // goods with B, payment with A
+	LineFailed      LineStatus = "FAILED"      // verification mismatch, held for the dispute
+)
+
+type OrderLine struct {
+	Amount         uint64            `json:"amount"` // price B pays for this line
+	AssetID        string            `json:"assetID"`
+	Outcome        SettlementOutcome `json:"outcome"`
+	Quantity       uint64            `json:"quantity"`       // chips taken from the lot, the rest stays with A
+	ReceiverReveal string            `json:"receiverReveal"` // B's revealed value, salted like the lot
+	SenderReveal   string            `json:"senderReveal"`   // A's revealed value, salted like the lot
+	Status         LineStatus        `json:"status"`
+	VerifyRef      string            `json:"verifyRef"` // key of this line's value in a reveal, defaults to assetID
+}
+
+// assetIDs lists the lots the escrow sells.
+func (e *EscrowContract) assetIDs() []string {
+	if len(e.Lines) == 0 {
+		return []string{e.AssetID}
+	}
+	ids := make([]string, len(e.Lines))
+	for i, line := range e.Lines {
+		ids[i] = line.AssetID
+	}
+	return ids
+}
+
+// requireLines fails unless every ref names a line of the escrow.
+func (e *EscrowContract) requireLines(refs []string) error {
+	for _, ref := range refs {
+		found := false
+		for _, line := range e.Lines {
+			if line.VerifyRef == ref {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("escrow %s has no line %s", e.TxnID, ref)
+		}
+	}
+	return nil
+}
+
+// copyLines gives the escrow its own Lines slice before a line is changed, so
+// a snapshot taken with old := *escrow keeps the previous line states.
+func (e *EscrowContract) copyLines() {
+	e.Lines = append([]OrderLine(nil), e.Lines...)
+}
+
+// InitOrder drafts a multi-line escrow. Every line names a lot the caller
+// owns, the quantity taken from it and its price. A line taking part of a lot
+// is carved off at settlement, which needs a lot without registered units.
+// [invoke]
+func (s *SmartContract) InitOrder(ctx contractapi.TransactionContextInterface, txn, deliveryEntity, receiver string, deliveryStake uint64, lines []OrderLine) error {
+	x, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+	if len(lines) == 0 {
+		return fmt.Errorf("an order needs at least one line")
+	}
+	if err := validateEscrowDraft(ctx, txn, x, deliveryEntity, receiver); err != nil {
+		return err
+	}
+
+	var total uint64
+	assets := map[string]bool{}
+	refs := map[string]bool{}
+	for i := range lines {
+		line := &lines[i]
+		if line.VerifyRef == "" {
+			line.VerifyRef = line.AssetID
+		}
+		if assets[line.AssetID] {
+			return fmt.Errorf("asset %s is on more than one line", line.AssetID)
+		}
+		if refs[line.VerifyRef] {
+			return fmt.Errorf("verifyRef %s is on more than one line", line.VerifyRef)
+		}
+		assets[line.AssetID], refs[line.VerifyRef] = true, true
+
+		asset, err := s.ReadAsset(ctx, line.AssetID)
+		if err != nil {
+			return err
+		}
+		if asset.Owner != x {
+			return fmt.Errorf("Client doesnt own asset %s", line.AssetID)
+		}
+		if err := requireTradableLot(ctx, asset); err != nil {
+			return err
+		}
+		if line.Quantity == 0 || line.Quantity > asset.Quantity {
+			return fmt.Errorf("line quantity for %s must be between 1 and %d", line.AssetID, asset.Quantity)
+		}
+		if line.Quantity < asset.Quantity {
+			units, err := lotUnits(ctx, line.AssetID)
+			if err != nil {
+				return err
+			}
+			if len(units) > 0 {
+				return fmt.Errorf("lot %s has registered units, split it with SplitAsset first", line.AssetID)
+			}
+		}
+		if total+line.Amount < total {
+			return fmt.Errorf("order total overflows")
+		}
+		total += line.Amount
+		line.Outcome, line.ReceiverReveal, line.SenderReveal = "", "", ""
+		line.Status = LinePending
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	escrow := EscrowContract{
+		Deadlines:     newDeadlines(now),
+		Delivery:      deliveryEntity,
+		DeliveryStake: deliveryStake,
+		EscrowAmount:  total,
+		Lines:         lines,
+		Receiver:      receiver,
+		Sender:        x,
+		Status:        StatusDrafted,
+		TxnID:         txn,
+	}
+	if err := acquireAssetLocks(ctx, &escrow); err != nil {
+		return err
+	}
+
+	return s.recordEscrow(ctx, EventEscrowInitiated, nil, &escrow)
+}
+
+// fundLines marks the lines B pays for, all pending ones when refs is nil,
+// and sets the escrow amount to their total. The other lines are rejected
+// and their lots unlocked.
+func (s *SmartContract) fundLines(ctx contractapi.TransactionContextInterface, escrow *EscrowContract, refs []string) error {
+	if err := escrow.requireLines(refs); err != nil {
+		return err
+	}
+	escrow.copyLines()
+	accepted := map[string]bool{}
+	for _, ref := range refs {
+		accepted[ref] = true
+	}
+
+	var total uint64
+	for i := range escrow.Lines {
+		line := &escrow.Lines[i]
+		if refs == nil || accepted[line.VerifyRef] {
+			line.Status = LineFunded
+			total += line.Amount
+			continue
+		}
+		line.Status = LineRejected
+		if err := releaseLocksOn(ctx, escrow.TxnID, line.AssetID); err != nil {
+			return err
+		}
+	}
+	escrow.EscrowAmount = total
+	return nil
+}
+
+// FundOrderLines is StartDelivery for part of an order: B pays for the lines
+// listed by verifyRef and rejects the rest. [invoke]
+// DRAFTED -> FUNDED
+func (s *SmartContract) FundOrderLines(ctx contractapi.TransactionContextInterface, txn string, refs []string) error {
+	escrow, err := s.readEscrow(ctx, txn)
+	if err != nil {
+		return err
+	}
+	old := *escrow
+	if _, err := requireCaller(ctx, escrow.Receiver, "receiver (B)"); err != nil {
+		return err
+	}
+	if err := requireActiveStakeholder(ctx, escrow.Receiver); err != nil {
+		return err
+	}
+	if len(escrow.Lines) == 0 {
+		return fmt.Errorf("escrow %s is not an order, use StartDelivery", txn)
+	}
+	if len(refs) == 0 {
+		return fmt.Errorf("no lines to fund, use StartDelivery to reject the order")
+	}
+	if err := escrow.transition(StatusFunded); err != nil {
+		return err
+	}
+	if err := s.fundLines(ctx, escrow, refs); err != nil {
+		return err
+	}
+	// B pays for the accepted lines
+	if err := escrow.lockEscrowAmount(); err != nil {
+		return err
+	}
+
+	return s.recordEscrow(ctx, EventEscrowFunded, &old, escrow)
+}
+
+// confirmLines marks the funded lines D delivered, all of them when refs is
+// nil. The others are settled at once: B gets the line's price back and A the
+// matching share of D's stake.
+func (s *SmartContract) confirmLines(ctx contractapi.TransactionContextInterface, escrow *EscrowContract, refs []string) error {
+	if err := escrow.requireLines(refs); err != nil {
+		return err
+	}
+	escrow.copyLines()
+	delivered := map[string]bool{}
+	for _, ref := range refs {
+		delivered[ref] = true
+	}
+
+	funded, stake := escrow.EscrowAmount, escrow.LockedStake
+	missed := false
+	for i := range escrow.Lines {
+		line := &escrow.Lines[i]
+		if line.Status != LineFunded {
+			if delivered[line.VerifyRef] {
+				return fmt.Errorf("line %s of escrow %s is %s", line.VerifyRef, escrow.TxnID, line.Status)
+			}
+			continue
+		}
+		if refs == nil || delivered[line.VerifyRef] {
+			line.Status = LineDelivered
+			continue
+		}
+
+		// D's stake is forfeited in proportion to the line's share of the order
+		var share uint64
+		if funded > 0 {
+			hi, lo := bits.Mul64(stake, line.Amount)
+			share, _ = bits.Div64(hi, lo, funded)
+		}
+		if err := escrow.pay(escrow.Receiver, line.Amount); err != nil {
+			return err
+		}
+		if err := escrow.pay(escrow.Sender, share); err != nil {
+			return err
+		}
+		escrow.LockedAmount -= line.Amount
+		escrow.LockedStake -= share
+		line.Outcome = OutcomeDeliveryTimeout
+		line.Status = LineUndelivered
+		if err := releaseLocksOn(ctx, escrow.TxnID, line.AssetID); err != nil {
+			return err
+		}
+		missed = true
+	}
+	if missed {
+		return recordReputation(ctx, escrow, OutcomeDeliveryTimeout)
+	}
+	return nil
+}
+
+// ConfirmOrderLines is ConfirmDelivery for part of an order: D lists the
+// lines it delivered by verifyRef, the other funded lines are refunded. [invoke]
+// HANDED_TO_CARRIER -> DELIVERED
+func (s *SmartContract) ConfirmOrderLines(ctx contractapi.TransactionContextInterface, txn string, refs []string) error {
+	escrow, err := s.readEscrow(ctx, txn)
+	if err != nil {
+		return err
+	}
+	old := *escrow
+	if _, err := requireCaller(ctx, escrow.Delivery, "delivery entity (D)"); err != nil {
+		return err
+	}
+	if err := requireActiveStakeholder(ctx, escrow.Delivery); err != nil {
+		return err
+	}
+	if len(escrow.Lines) == 0 {
+		return fmt.Errorf("escrow %s is not an order, use ConfirmDelivery", txn)
+	}
+	if len(refs) == 0 {
+		return fmt.Errorf("no line delivered, use ClaimTimeout once the deadline passes")
+	}
+	if err := escrow.transition(StatusDelivered); err != nil {
+		return err
+	}
+	if err := s.confirmLines(ctx, escrow, refs); err != nil {
+		return err
+	}
+	if err := openRevealPhase(ctx, escrow); err != nil {
+		return err
+	}
+
+	return s.recordEscrow(ctx, EventDeliveryConfirmed, &old, escrow)
+}
+
+// orderLineHashes hashes a revealed JSON object of verifyRef -> value with
+// each delivered line's lot salt. Other lines get "".
+func (s *SmartContract) orderLineHashes(ctx contractapi.TransactionContextInterface, escrow *EscrowContract, value string) ([]string, error) {
+	var values map[string]string
+	if err := json.Unmarshal([]byte(value), &values); err != nil {
+		return nil, fmt.Errorf("escrow %s is an order, the revealed value must be a JSON object of verifyRef to value: %v", escrow.TxnID, err)
+	}
+	hashes := make([]string, len(escrow.Lines))
+	for i, line := range escrow.Lines {
+		if line.Status != LineDelivered {
+			continue
+		}
+		lot, err := s.ReadAsset(ctx, line.AssetID)
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = hashVerifyValue(lot.VerifySalt, values[line.VerifyRef])
+	}
+	return hashes, nil
+}
+
+// revealLines stores one side's per-line reveal.
+func (s *SmartContract) revealLines(ctx contractapi.TransactionContextInterface, escrow *EscrowContract, sender bool, value string) error {
+	hashes, err := s.orderLineHashes(ctx, escrow, value)
+	if err != nil {
+		return err
+	}
+	escrow.copyLines()
+	for i := range escrow.Lines {
+		if sender {
+			escrow.Lines[i].SenderReveal = hashes[i]
+		} else {
+			escrow.Lines[i].ReceiverReveal = hashes[i]
+		}
+	}
+	return nil
+}
+
+// deliverLine hands a line's chips to the receiver: the whole lot, or a new
+// lot carved off it.
+func (s *SmartContract) deliverLine(ctx contractapi.TransactionContextInterface, escrow *EscrowContract, line *OrderLine, outcome SettlementOutcome) error {
+	lot, err := s.ReadAsset(ctx, line.AssetID)
+	if err != nil {
+		return err
+	}
+	line.Outcome = outcome
+	line.Status = LineCompleted
+	if line.Quantity >= lot.Quantity {
+		lot.Owner = escrow.Receiver
+		lot.LastEscrow = escrow.TxnID
+		if err := s.putAsset(ctx, lot); err != nil {
+			return err
+		}
+		return moveLotUnits(ctx, lot.ChipID, escrow.Sender, escrow.Receiver, escrow.TxnID)
+	}
+
+	childID := lot.ChipID + "-" + escrow.TxnID
+	exists, err := s.AssetExists(ctx, childID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("the asset %s already exists", childID)
+	}
+	child := childLot(lot, childID, line.Quantity)
+	child.Owner = escrow.Receiver
+	child.LastEscrow = escrow.TxnID
+	lot.Quantity -= line.Quantity
+	if err := s.putAsset(ctx, lot); err != nil {
+		return err
+	}
+	return s.putAsset(ctx, &child)
+}
+
+// settleOrderVerification settles every delivered line on its own. Matching
+// lines pay A and hand the chips to B straight away. If any line failed, the
+// escrow goes to dispute holding only the failed lines' funds and the stake.
+func (s *SmartContract) settleOrderVerification(ctx contractapi.TransactionContextInterface, escrow *EscrowContract) error {
+	escrow.copyLines()
+	finding := OutcomeAllMatch
+	for i := range escrow.Lines {
+		line := &escrow.Lines[i]
+		if line.Status != LineDelivered {
+			continue
+		}
+		lot, err := s.ReadAsset(ctx, line.AssetID)
+		if err != nil {
+			return err
+		}
+		outcome := revealFinding(lot.VerifyHash, line.SenderReveal, line.ReceiverReveal)
+		if lot.CompromisedBy != "" {
+			outcome = OutcomeSenderAtFault
+		}
+		if outcome != OutcomeAllMatch && outcome != OutcomeVerifyTimeout {
+			line.Outcome = outcome
+			line.Status = LineFailed
+			if finding != OutcomeSenderAtFault {
+				finding = outcome
+			}
+			continue
+		}
+		if finding == OutcomeAllMatch {
+			finding = outcome // B's silence accepted the line
+		}
+
+		if err := escrow.pay(escrow.Sender, line.Amount); err != nil {
+			return err
+		}
+		escrow.LockedAmount -= line.Amount
+		if err := s.deliverLine(ctx, escrow, line, outcome); err != nil {
+			return err
+		}
+		if err := releaseLocksOn(ctx, escrow.TxnID, line.AssetID); err != nil {
+			return err
+		}
+	}
+
+	return s.settleOutcome(ctx, escrow, finding)
+}
@@ -0,0 +1,83 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import "testing"
+
+// orderFixture stores one lot per line, all matching the lines' reveals, and
+// returns an order escrow of them from Org1 to Org2 carried by Org3.
+func orderFixture(t *testing.T, s *SmartContract, ctx *testContext, amounts ...uint64) *EscrowContract {
+	escrow := &EscrowContract{
+		Delivery: "Org3MSP",
+		DocType:  "escrow",
+		Receiver: "Org2MSP",
+		Sender:   "Org1MSP",
+		Status:   StatusDelivered,
+		TxnID:    "order1",
+	}
+	for i, amount := range amounts {
+		id := "lot" + string(rune('A'+i))
+		hash := hashVerifyValue("salt", "value"+id)
+		lot := &Asset{ChipID: id, DocType: "asset", Owner: escrow.Sender, Quantity: 10, VerifyHash: hash, VerifySalt: "salt"}
+		if err := s.putAsset(ctx, lot); err != nil {
+			t.Fatal(err)
+		}
+		escrow.Lines = append(escrow.Lines, OrderLine{
+			Amount:         amount,
+			AssetID:        id,
+			Quantity:       10,
+			ReceiverReveal: hash,
+			SenderReveal:   hash,
+			Status:         LineDelivered,
+			VerifyRef:      id,
+		})
+		escrow.EscrowAmount += amount
+	}
+	escrow.LockedAmount = escrow.EscrowAmount
+	ctx.stub.commit()
+	return escrow
+}
+
+func TestSettleOrderVerificationPaysEveryLine(t *testing.T) {
+	s := &SmartContract{}
+	ctx := &testContext{stub: newLedgerStub(), identity: &mspIdentity{mspID: "Org2MSP"}}
+	escrow := orderFixture(t, s, ctx, 100, 200)
+	escrow.LockedStake = 50
+
+	if err := s.settleOrderVerification(ctx, escrow); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+
+	requireBalance(t, s, ctx, "Org1MSP", 300)
+	requireBalance(t, s, ctx, "Org3MSP", 50)
+	if escrow.Status != StatusVerified {
+		t.Errorf("status: got %s, want %s", escrow.Status, StatusVerified)
+	}
+}
+
+func TestConfirmLinesRefundsEveryMissedLine(t *testing.T) {
+	s := &SmartContract{}
+	ctx := &testContext{stub: newLedgerStub(), identity: &mspIdentity{mspID: "Org3MSP"}}
+	escrow := orderFixture(t, s, ctx, 100, 200, 300)
+	escrow.LockedStake = 60
+	for i := range escrow.Lines {
+		escrow.Lines[i].Status = LineFunded
+	}
+
+	if err := s.confirmLines(ctx, escrow, []string{"lotC"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.recordEscrow(ctx, EventDeliveryConfirmed, nil, escrow); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+
+	requireBalance(t, s, ctx, "Org2MSP", 300)
+	requireBalance(t, s, ctx, "Org1MSP", 30)
+	if escrow.LockedAmount != 300 || escrow.LockedStake != 30 {
+		t.Errorf("locked: got %d and %d, want 300 and 30", escrow.LockedAmount, escrow.LockedStake)
+	}
+}
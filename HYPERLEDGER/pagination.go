@@ -0,0 +1,117 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// PaginatedQueryResult is one page of assets plus the bookmark for the next page.
+// An empty bookmark with FetchedRecordsCount < pageSize means the listing is done.
+type PaginatedQueryResult struct {
+	Bookmark            string   `json:"bookmark"`
+	FetchedRecordsCount int32    `json:"fetchedRecordsCount"`
+	Records             []*Asset `json:"records"`
+}
+
+// GetAssetsWithPagination returns one page of all assets. [query]
+func (s *SmartContract) GetAssetsWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*PaginatedQueryResult, error) {
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("page size must be positive")
+	}
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(assetObjectType, []string{}, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var assets []*Asset
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var asset Asset
+		err = json.Unmarshal(queryResponse.Value, &asset)
+		if err != nil {
+			return nil, err
+		}
+		assets = append(assets, &asset)
+	}
+
+	return &PaginatedQueryResult{
+		Bookmark:            metadata.Bookmark,
+		FetchedRecordsCount: metadata.FetchedRecordsCount,
+		Records:             assets,
+	}, nil
+}
+
+// GetAssetsByOwnerWithPagination returns one page of the assets held by owner. [query]
+func (s *SmartContract) GetAssetsByOwnerWithPagination(ctx contractapi.TransactionContextInterface, owner string, pageSize int32, bookmark string) (*PaginatedQueryResult, error) {
+	return s.getAssetsByIndex(ctx, ownerIndex, owner, pageSize, bookmark)
+}
+
+// GetAssetsByChipNameWithPagination returns one page of the assets with the given chip name. [query]
+func (s *SmartContract) GetAssetsByChipNameWithPagination(ctx contractapi.TransactionContextInterface, chipName string, pageSize int32, bookmark string) (*PaginatedQueryResult, error) {
+	return s.getAssetsByIndex(ctx, chipNameIndex, chipName, pageSize, bookmark)
+}
+
+// GetAssetsByManufacturerWithPagination returns one page of the assets minted by manufacturer. [query]
+func (s *SmartContract) GetAssetsByManufacturerWithPagination(ctx contractapi.TransactionContextInterface, manufacturer string, pageSize int32, bookmark string) (*PaginatedQueryResult, error) {
+	return s.getAssetsByIndex(ctx, manufacturerIndex, manufacturer, pageSize, bookmark)
+}
+
+// getAssetsByIndex pages through one secondary index and loads the assets it points at.
+func (s *SmartContract) getAssetsByIndex(ctx contractapi.TransactionContextInterface, index, value string, pageSize int32, bookmark string) (*PaginatedQueryResult, error) {
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("page size must be positive")
+	}
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(index, []string{value}, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	assets, err := s.assetsFromIndex(ctx, resultsIterator)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PaginatedQueryResult{
+		Bookmark:            metadata.Bookmark,
+		FetchedRecordsCount: metadata.FetchedRecordsCount,
+		Records:             assets,
+	}, nil
+}
+
+// assetsFromIndex resolves index entries to the assets they reference.
+func (s *SmartContract) assetsFromIndex(ctx contractapi.TransactionContextInterface, resultsIterator shim.StateQueryIteratorInterface) ([]*Asset, error) {
+	var assets []*Asset
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(attributes) != 2 {
+			return nil, fmt.Errorf("malformed index key %q", queryResponse.Key)
+		}
+
+		asset, err := s.ReadAsset(ctx, attributes[1])
+		if err != nil {
+			return nil, err
+		}
+		assets = append(assets, asset)
+	}
+	return assets, nil
+}
@@ -21,52 +21,124 @@ type SmartContract struct {
 type Asset struct {
 	ChipID         string `json:"id"` // UNIQUE asset#
 	ChipName       string `json:"chipName"`
+	CompromisedBy  string `json:"compromisedBy"` // confirmed counterfeit report, "" if none
+	Components     []string `json:"components"` // lots assembled into this product, see AssembleProduct
+	ConsumedBy     string `json:"consumedBy"` // product this lot was assembled into, "" while tradable
+	DocType        string `json:"docType"` // always "asset", lets CouchDB selectors tell record types apart
+	LastEscrow     string `json:"lastEscrow"` // escrow that caused the latest ownership change
+	Manufacturer   string `json:"manufacturer"` // MSP ID that minted the asset
+	MergedFrom     []string `json:"mergedFrom"` // lots folded into this one by MergeAssets, directly or not
 	Owner          string `json:"owner"`
+	ParentID       string `json:"parentID"` // lot this one was split from, "" if minted
 	Quantity       uint64 `json:"quantity"`
-	VerifyValue    string `json:"value"`
+	RecallID       string `json:"recallID"` // recall that flagged this lot, "" if none
+	VerifyHash     string `json:"verifyHash"` // hashVerifyValue(VerifySalt, manufacturer value)
+	VerifySalt     string `json:"verifySalt"`
 }
 
+// Check is the legacy single-manufacturer record stored under "1". It is only
+// read to carry an existing manufacturer over into the registry.
 type Check struct {
 	ID 		string      `json:"id"` // string
 	Pack    string   `json:"pack"`
 }
 
 type EscrowContract struct { // initiated by A (sender)
-	AssetID           string `json: "assetID"`
-	ConfirmDelivery   bool	`json: "confirmDelivery"`
+	AbortApprovals    []string `json:"abortApprovals"` // MutualAbort signatures from A and B
+	AssetID           string `json:"assetID"`
+	Deadlines         Deadlines `json:"deadlines"` // set at Init, see ClaimTimeout
 	Delivery    	  string `json:"delivery"`
 	DeliveryStake     uint64 `json:"deliveryStake"`
-	DisputeFlag       bool	`json:"disputeFlag"`
+	DocType           string `json:"docType"` // always "escrow"
 	EscrowAmount      uint64 `json:"escrowAmount"`
-	InitiateDelivery  bool	`json: "initiateDelivery"`
+	Lines             []OrderLine `json:"lines"` // order lines, see InitOrder; empty for a single-lot escrow
+	LockedAmount      uint64 `json:"lockedAmount"` // escrow tokens held from receiver
+	LockedStake       uint64 `json:"lockedStake"`  // stake tokens held from delivery
+	Outcome           SettlementOutcome `json:"outcome"` // why the escrow closed
 	Receiver          string `json:"receiver"`
+	ReceiverCommitment string `json:"receiverCommitment"` // hashVerifyValue(nonce, value) from B
+	ReceiverReveal    string `json:"receiverReveal"`       // B's revealed value, salted like the asset
+	ReceiverUnitReveals []string `json:"receiverUnitReveals"` // B's reveal per unit, in Serials order
+	RevealDeadline    int64  `json:"revealDeadline"`       // unix seconds, 0 until both commitments are in
 	Sender            string `json:"sender"` 
-	StartDelivery 	  bool	`json: "startDelivery"`
-	TransactionCompleted	bool    `json:"transactionCompleted"`
-	TxnID			  string `json: "txnID"` //Txn1	
-	Verify		   	  string `json:"value"`
+	SenderCommitment  string `json:"senderCommitment"`     // hashVerifyValue(nonce, value) from A
+	SenderReveal      string `json:"senderReveal"`         // A's revealed value, salted like the asset
+	SenderUnitReveals []string `json:"senderUnitReveals"`  // A's reveal per unit, in Serials order
+	Serials           []string `json:"serials"`            // units of the lot being sold, empty for the whole lot
+	Status            EscrowStatus `json:"status"`
+	TxnID			  string `json:"txnID"` //Txn1	
+	UnitFindings      map[string]SettlementOutcome `json:"unitFindings"` // serial -> verification finding, set when the escrow names serials
+
+	moves tokenMoves // token movements booked in this transaction, see recordEscrow
 }
 
-// InitLedger run by manurfacturer. Sets assets list. Whoever runs this is the 
-// default (single) manufacturer. [invoke]
+// InitLedger run by manurfacturer. Whoever runs this first becomes the admin org,
+// the first registered manufacturer and the founding stakeholder. It can only
+// run once. [invoke]
 func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface) error {
-	// asset {ChipID(primary key), "asset1", ChipName: "Intel Core i9 10900K", Owner: "Tomoko", Quantity: 5, Value: 300},
-
-	//assets := []Asset{}
-	x, _ := ctx.GetClientIdentity().GetMSPID()
-	manufacturer := Check{ID: "1", Pack: x}
-	
-	manufacturerJSON, err := json.Marshal(manufacturer)
+	config, err := readConfig(ctx)
 	if err != nil {
 		return err
 	}
-	
-	err = ctx.GetStub().PutState(manufacturer.ID, manufacturerJSON)
+	if config != nil {
+		return fmt.Errorf("ledger is already initialised, admin is %s", config.Admin)
+	}
+
+	x, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+	admin := x
+	// a ledger written before the registry keeps its original manufacturer
+	legacyJSON, err := ctx.GetStub().GetState(legacyManufacturerKey)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if legacyJSON != nil {
+		var legacy Check
+		err = json.Unmarshal(legacyJSON, &legacy)
+		if err != nil {
+			return err
+		}
+		admin = legacy.Pack
+	}
+
+	err = putConfig(ctx, &Config{Admin: admin})
 	if err != nil {
 		return fmt.Errorf("failed to put to world state. %v", err)
 	}
 
-	return nil
+	// the manufacturer is also the founding stakeholder (voting.sol constructor)
+	founder, err := readStakeholder(ctx, admin)
+	if err != nil {
+		return err
+	}
+	if founder == nil {
+		ts, err := txTimestamp(ctx)
+		if err != nil {
+			return err
+		}
+		founder = &Stakeholder{
+			Active:           true,
+			Capabilities:     []string{CapabilityShip, CapabilityReceive},
+			Description:      "Manufactures several components CPU, RAM, and chipsets.",
+			InvolvedProducts: []string{},
+			Maker:            admin,
+			MSPID:            admin,
+			Name:             "Manufacturer",
+			Timestamp:        ts,
+		}
+		if err := putStakeholder(ctx, founder); err != nil {
+			return err
+		}
+	}
+
+	return putManufacturer(ctx, &Manufacturer{
+		AddedBy: admin,
+		MSPID:   admin,
+		Name:    founder.Name,
+		Status:  ManufacturerActive,
+	})
 }
 
 // return txn callers identity [query]
@@ -75,14 +147,16 @@ func (s *SmartContract) getIdentity(ctx contractapi.TransactionContextInterface)
 	return id, err
 }
 
-// CreateAsset issues a new asset to the world state with given details. [invoke]
-func (s *SmartContract) CreateAsset(ctx contractapi.TransactionContextInterface, ID string, Name string, Qty uint64, Val string) error {
-	manufacturer, _ := ctx.GetClientIdentity().GetMSPID()
-	xy, _ := ctx.GetStub().GetState("1")
-	var x Check
-	err := json.Unmarshal(xy, &x)
-	if manufacturer != x.Pack{
-		return fmt.Errorf("this entity is not a manufacturer and cannot create assets")
+// CreateAsset issues a new asset to the world state with given details. The
+// verification value is read from the transient map, only its salted hash
+// is made public. [invoke]
+func (s *SmartContract) CreateAsset(ctx contractapi.TransactionContextInterface, ID string, Name string, Qty uint64) error {
+	manufacturer, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+	if err := requireActiveManufacturer(ctx, manufacturer); err != nil {
+		return err
 	}
 
 	exists, err := s.AssetExists(ctx, ID)
@@ -92,31 +166,90 @@ func (s *SmartContract) CreateAsset(ctx contractapi.TransactionContextInterface,
 	if exists {
 		return fmt.Errorf("the asset %s already exists", ID)
 	}
+	val, err := transientVerifyValue(ctx)
+	if err != nil {
+		return err
+	}
+	salt := ctx.GetStub().GetTxID()
 	
 	asset := Asset{
 		ChipID: 	ID,
 		ChipName:   Name,
+		Components:   []string{},
+		Manufacturer: manufacturer,
+		MergedFrom:   []string{},
 		Owner: 		manufacturer,
 		Quantity:   Qty,
-		VerifyValue: 		Val,
+		VerifyHash: 	hashVerifyValue(salt, val),
+		VerifySalt: 	salt,
 	}
-	assetJSON, err := json.Marshal(asset)
+	err = s.putAsset(ctx, &asset)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	err = putVerifySecret(ctx, manufacturer, ID, salt, val)
 	if err != nil {
 		return err
 	}
 
-	err = ctx.GetStub().PutState(ID, assetJSON)
+	return emitEvent(ctx, EventAssetCreated, ID, nil, &asset)
+}
+
+// putAsset writes the asset under its "asset" composite key and keeps the
+// owner, chipName and manufacturer indexes in step with it.
+func (s *SmartContract) putAsset(ctx contractapi.TransactionContextInterface, asset *Asset) error {
+	key, err := assetKey(ctx, asset.ChipID)
 	if err != nil {
-		return fmt.Errorf("failed to put to world state. %v", err)
+		return err
+	}
+	previousJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if previousJSON != nil {
+		var previous Asset
+		err = json.Unmarshal(previousJSON, &previous)
+		if err != nil {
+			return err
+		}
+		staleKeys, err := assetIndexKeys(ctx, &previous)
+		if err != nil {
+			return err
+		}
+		for _, staleKey := range staleKeys {
+			if err := ctx.GetStub().DelState(staleKey); err != nil {
+				return err
+			}
+		}
 	}
 
-	return nil
+	asset.DocType = assetObjectType
+	indexKeys, err := assetIndexKeys(ctx, asset)
+	if err != nil {
+		return err
+	}
+	for _, indexKey := range indexKeys {
+		if err := ctx.GetStub().PutState(indexKey, []byte{0x00}); err != nil {
+			return err
+		}
+	}
+
+	assetJSON, err := json.Marshal(asset)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, assetJSON)
 }
 
 // ReadAsset returns the asset stored in the world state with given id. [query]
 func (s *SmartContract) ReadAsset(ctx contractapi.TransactionContextInterface, id string) (*Asset, error) {
-	assetJSON, err := ctx.GetStub().GetState(id)
-	
+	key, err := assetKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	assetJSON, err := ctx.GetStub().GetState(key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read from world state: %v", err)
 	}
@@ -137,7 +270,11 @@ func (s *SmartContract) ReadAsset(ctx contractapi.TransactionContextInterface, i
 
 // AssetExists returns true when asset with given ID exists in world state
 func (s *SmartContract) AssetExists(ctx contractapi.TransactionContextInterface, id string) (bool, error) {
-	assetJSON, err := ctx.GetStub().GetState(id)
+	key, err := assetKey(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	assetJSON, err := ctx.GetStub().GetState(key)
 	if err != nil {
 		return false, fmt.Errorf("failed to read from world state: %v", err)
 	}
@@ -146,11 +283,52 @@ func (s *SmartContract) AssetExists(ctx contractapi.TransactionContextInterface,
 }
 
 
+// TransferAsset updates the owner field of asset with given id in world state, and returns the old owner.
+// Only the current owner can hand the asset to another active stakeholder. [invoke]
+func (s *SmartContract) TransferAsset(ctx contractapi.TransactionContextInterface, id string, newOwner string) (string, error) {
+	asset, err := s.ReadAsset(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if _, err := requireCaller(ctx, asset.Owner, "the asset owner"); err != nil {
+		return "", err
+	}
+	if err := requireTradableLot(ctx, asset); err != nil {
+		return "", err
+	}
+	if err := requireUnlocked(ctx, id, nil); err != nil {
+		return "", err
+	}
+	if err := requireActiveStakeholder(ctx, newOwner); err != nil {
+		return "", err
+	}
+
+	old := *asset
+	oldOwner := asset.Owner
+	asset.Owner = newOwner
+	asset.LastEscrow = "" // direct transfer, no escrow involved
+
+	err = s.putAsset(ctx, asset)
+	if err != nil {
+		return "", err
+	}
+	err = moveLotUnits(ctx, id, oldOwner, newOwner, "")
+	if err != nil {
+		return "", err
+	}
+	err = emitEvent(ctx, EventAssetTransferred, id, &old, asset)
+	if err != nil {
+		return "", err
+	}
+
+	return oldOwner, nil
+}
+
 // GetAllAssets returns all assets found in world state
 func (s *SmartContract) GetAllAssets(ctx contractapi.TransactionContextInterface) ([]*Asset, error) {
-	// range query with empty string for startKey and endKey does an
-	// open-ended query of all assets in the chaincode namespace.
-	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	// partial composite key query with no attributes returns every key
+	// of the "asset" object type and nothing else.
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(assetObjectType, []string{})
 	if err != nil {
 		return nil, err
 	}
@@ -177,8 +355,10 @@ func (s *SmartContract) GetAllAssets(ctx contractapi.TransactionContextInterface
 // #################################################
 
 
-// Client drafts order. Checks if order is valid. [invoke]
-func (s *SmartContract) Init(ctx contractapi.TransactionContextInterface, txn, assetID, deliveryEntity, receiver, verify string, escrowAmount, deliveryStake uint64) error {
+// Client drafts order. Checks if order is valid. The sender commits to its
+// verification value separately with CommitVerification. serials names the
+// units of the lot being sold, empty sells the whole lot. [invoke]
+func (s *SmartContract) Init(ctx contractapi.TransactionContextInterface, txn, assetID, deliveryEntity, receiver string, escrowAmount, deliveryStake uint64, serials []string) error {
 	// Check if asset exists
 	asset, err := s.ReadAsset(ctx, assetID)
 	if err != nil {
@@ -186,173 +366,374 @@ func (s *SmartContract) Init(ctx contractapi.TransactionContextInterface, txn, a
 	}
 	// Check is client owns asset
 	x, err := ctx.GetClientIdentity().GetMSPID()
-	if asset.Owner !=  x {
-		return fmt.Errorf("Client doesnt own asset %v", err)
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+	if len(serials) == 0 && asset.Owner != x {
+		return fmt.Errorf("Client doesnt own asset %s", assetID)
+	}
+	if len(serials) == 0 {
+		err = requireTradableLot(ctx, asset)
+	} else {
+		err = requireTradable(asset)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := ownedUnits(ctx, assetID, serials, x); err != nil {
+		return err
+	}
+	if err := validateEscrowDraft(ctx, txn, x, deliveryEntity, receiver); err != nil {
+		return err
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
 	}
-	// # Check is delivery, receiver exist in same channel
-
 
 	// create a transaction for this asset
-	escrow := EscrowContract {
-		AssetID: assetID,
-		ConfirmDelivery: false,
-		Delivery: deliveryEntity,
+	escrow := EscrowContract{
+		AssetID:       assetID,
+		Deadlines:     newDeadlines(now),
+		Delivery:      deliveryEntity,
 		DeliveryStake: deliveryStake,
-		DisputeFlag: false,
-		EscrowAmount: escrowAmount,
-		InitiateDelivery: false,
-		Receiver: receiver,
-		Sender: x,
-		StartDelivery: false,
-		TransactionCompleted: false,
-		TxnID: txn, //Txn1	
-		Verify: verify,
+		EscrowAmount:  escrowAmount,
+		Receiver:      receiver,
+		Sender:        x,
+		Serials:       serials,
+		Status:        StatusDrafted,
+		TxnID:         txn, //Txn1
+	}
+	if err := acquireAssetLocks(ctx, &escrow); err != nil {
+		return err
 	}
 
-	escrowJSON, err := json.Marshal(escrow)
+	return s.recordEscrow(ctx, EventEscrowInitiated, nil, &escrow)
+}
+
+// validateEscrowDraft checks the parties of a new escrow and that txn is unused.
+func validateEscrowDraft(ctx contractapi.TransactionContextInterface, txn, sender, deliveryEntity, receiver string) error {
+	// # Check is delivery, receiver exist in same channel
+	if err := requireActiveStakeholder(ctx, sender); err != nil {
+		return err
+	}
+	if err := requireCapability(ctx, deliveryEntity, CapabilityShip); err != nil {
+		return err
+	}
+	if err := requireCapability(ctx, receiver, CapabilityReceive); err != nil {
+		return err
+	}
+	for _, party := range []string{sender, deliveryEntity, receiver} {
+		if err := requireGoodStanding(ctx, party); err != nil {
+			return err
+		}
+	}
+	config, err := readConfig(ctx)
 	if err != nil {
 		return err
 	}
-	err = ctx.GetStub().PutState(txn, escrowJSON)
+	if !config.AllowSharedParties && (sender == deliveryEntity || sender == receiver || deliveryEntity == receiver) {
+		return fmt.Errorf("sender, receiver and carrier must be three different orgs")
+	}
+
+	key, err := escrowKey(ctx, txn)
 	if err != nil {
 		return err
 	}
+	existing, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if existing != nil {
+		return fmt.Errorf("escrow %s already exists", txn)
+	}
+	return nil
+}
 
-	return err 
+// readEscrow loads the escrow stored under txn.
+func (s *SmartContract) readEscrow(ctx contractapi.TransactionContextInterface, txn string) (*EscrowContract, error) {
+	key, err := escrowKey(ctx, txn)
+	if err != nil {
+		return nil, err
+	}
+	escrowJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if escrowJSON == nil {
+		return nil, fmt.Errorf("the escrow %s does not exist", txn)
+	}
+
+	var escrow EscrowContract
+	err = json.Unmarshal(escrowJSON, &escrow)
+	if err != nil {
+		return nil, err
+	}
+
+	return &escrow, nil
 }
 
-// delivery PROCESS started by receiver. [invoke]
-func (s *SmartContract) StartDelivery(ctx contractapi.TransactionContextInterface, txn string, decision bool) error {
-	// how to access stuct data using object. or else we'll just add txnID, access getState()
-	escrow, err := ctx.GetStub().GetState(txn)
-	var escrowJSON EscrowContract
-	err = json.Unmarshal(escrow, &escrowJSON)
-	
+// putEscrow writes the escrow under its "escrow" composite key and indexes
+// it under its assets.
+func (s *SmartContract) putEscrow(ctx contractapi.TransactionContextInterface, escrow *EscrowContract) error {
+	key, err := escrowKey(ctx, escrow.TxnID)
 	if err != nil {
-		return fmt.Errorf("failed to read from world state: %v", err)
+		return err
+	}
+	for _, assetID := range escrow.assetIDs() {
+		indexKey, err := ctx.GetStub().CreateCompositeKey(escrowAssetIndex, []string{assetID, escrow.TxnID})
+		if err != nil {
+			return err
+		}
+		if err := ctx.GetStub().PutState(indexKey, []byte{0x00}); err != nil {
+			return err
+		}
 	}
+	escrow.DocType = escrowObjectType
+	escrowJSON, err := json.Marshal(escrow)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, escrowJSON)
+}
+
+// requireCaller checks that the client belongs to the expected MSP.
+func requireCaller(ctx contractapi.TransactionContextInterface, expected, role string) (string, error) {
 	x, err := ctx.GetClientIdentity().GetMSPID()
-	if x != escrowJSON.Receiver {
-		return fmt.Errorf("Only receiver (B) can start the delivery")
+	if err != nil {
+		return "", fmt.Errorf("failed to get client identity: %v", err)
 	}
-	if escrowJSON.TransactionCompleted {
-		return fmt.Errorf("Transaction is already completed")
+	if x != expected {
+		return "", fmt.Errorf("only %s can perform this step", role)
 	}
-	escrowJSON.StartDelivery = decision
-	escrow, err = json.Marshal(escrowJSON)
-	err = ctx.GetStub().PutState(txn, escrow)
+	return x, nil
+}
+
+// recordEscrow applies the token movements booked on the escrow, writes it and
+// emits eventType with its before and after state. Once the escrow is final its
+// asset locks are released.
+func (s *SmartContract) recordEscrow(ctx contractapi.TransactionContextInterface, eventType string, old, escrow *EscrowContract) error {
+	if err := s.settleTokenMoves(ctx, escrow); err != nil {
+		return err
+	}
+	if err := s.putEscrow(ctx, escrow); err != nil {
+		return err
+	}
+	if escrow.Status.IsFinal() {
+		if err := releaseAssetLocks(ctx, escrow); err != nil {
+			return err
+		}
+	}
+	return emitEvent(ctx, eventType, escrow.TxnID, old, escrow)
+}
+
+// ReadEscrow returns the escrow stored in the world state with given txn id. [query]
+func (s *SmartContract) ReadEscrow(ctx contractapi.TransactionContextInterface, txn string) (*EscrowContract, error) {
+	return s.readEscrow(ctx, txn)
+}
+
+// delivery PROCESS started by receiver. decision=false rejects the order. [invoke]
+// DRAFTED -> FUNDED | CANCELLED
+func (s *SmartContract) StartDelivery(ctx contractapi.TransactionContextInterface, txn string, decision bool) error {
+	escrow, err := s.readEscrow(ctx, txn)
 	if err != nil {
 		return err
 	}
+	old := *escrow
+	if _, err := requireCaller(ctx, escrow.Receiver, "receiver (B)"); err != nil {
+		return err
+	}
+	if err := requireActiveStakeholder(ctx, escrow.Receiver); err != nil {
+		return err
+	}
+
+	next, event := StatusFunded, EventEscrowFunded
+	if !decision {
+		next, event = StatusCancelled, EventEscrowCancelled
+	}
+	if err := escrow.transition(next); err != nil {
+		return err
+	}
+	if next == StatusFunded {
+		if len(escrow.Lines) > 0 {
+			if err := s.fundLines(ctx, escrow, nil); err != nil {
+				return err
+			}
+		}
+		// B pays the escrow amount into the contract
+		if err := escrow.lockEscrowAmount(); err != nil {
+			return err
+		}
+	}
 
-	return nil 
+	return s.recordEscrow(ctx, event, &old, escrow)
 }
 
-// ran by sender, to say they gave product to delivery. [invoke]
+// ran by sender, to say they gave product to delivery. decision=false withdraws.
+// Taking the goods commits D: its delivery stake is locked here, so that it can
+// be forfeited if D never delivers. [invoke]
+// FUNDED -> HANDED_TO_CARRIER | CANCELLED
 func (s *SmartContract) InitiateDelivery(ctx contractapi.TransactionContextInterface, txn string, decision bool) error {
-
-	escrow, err := ctx.GetStub().GetState(txn)
-	var escrowJSON EscrowContract
-	err = json.Unmarshal(escrow, &escrowJSON)
-	
+	escrow, err := s.readEscrow(ctx, txn)
 	if err != nil {
-		return fmt.Errorf("failed to read from world state: %v", err)
+		return err
 	}
-	x, err := ctx.GetClientIdentity().GetMSPID()
-	if x != escrowJSON.Sender {
-		return fmt.Errorf("Only sender (A) can start the delivery")
+	old := *escrow
+	if _, err := requireCaller(ctx, escrow.Sender, "sender (A)"); err != nil {
+		return err
 	}
-	if escrowJSON.TransactionCompleted {
-		return fmt.Errorf("Transaction is already completed")
+	if err := requireActiveStakeholder(ctx, escrow.Sender); err != nil {
+		return err
 	}
-	escrowJSON.InitiateDelivery = decision
-	escrow, err = json.Marshal(escrowJSON)
-	err = ctx.GetStub().PutState(txn, escrow)
-	if err != nil {
+
+	next, event := StatusHandedToCarrier, EventDeliveryInitiated
+	if !decision {
+		next, event = StatusCancelled, EventEscrowCancelled
+	}
+	if err := escrow.transition(next); err != nil {
 		return err
 	}
+	if next == StatusCancelled {
+		// return escrow to B
+		if err := escrow.releaseFunds(escrow.Receiver, escrow.Delivery); err != nil {
+			return err
+		}
+	} else {
+		// D deposits the delivery stake
+		if err := escrow.lockDeliveryStake(); err != nil {
+			return err
+		}
+	}
 
-	return nil 
+	return s.recordEscrow(ctx, event, &old, escrow)
 }
 
 // ran by delivery to say they finished their job of delivery. [invoke]
+// HANDED_TO_CARRIER -> DELIVERED
 func (s *SmartContract) ConfirmDelivery(ctx contractapi.TransactionContextInterface, txn string, decision bool) error {
-
-	escrow, err := ctx.GetStub().GetState(txn)
-	var escrowJSON EscrowContract
-	err = json.Unmarshal(escrow, &escrowJSON)
+	escrow, err := s.readEscrow(ctx, txn)
 	if err != nil {
-		return fmt.Errorf("failed to read from world state: %v", err)
+		return err
 	}
-	x, _ := ctx.GetClientIdentity().GetMSPID() 
-	if x != escrowJSON.Delivery {
-		return fmt.Errorf("Only delivery entity (D) can start the delivery")
+	old := *escrow
+	if _, err := requireCaller(ctx, escrow.Delivery, "delivery entity (D)"); err != nil {
+		return err
 	}
-	if escrowJSON.TransactionCompleted {
-		return fmt.Errorf("Transaction is already completed")
+	if err := requireActiveStakeholder(ctx, escrow.Delivery); err != nil {
+		return err
 	}
-	escrowJSON.ConfirmDelivery = decision
-	escrow, err = json.Marshal(escrowJSON)
-	err = ctx.GetStub().PutState(txn, escrow)
-	if err != nil {
+	if !decision {
+		return fmt.Errorf("delivery for escrow %s not confirmed, status stays %s", txn, escrow.Status)
+	}
+	if err := escrow.transition(StatusDelivered); err != nil {
+		return err
+	}
+	if len(escrow.Lines) > 0 {
+		if err := s.confirmLines(ctx, escrow, nil); err != nil {
+			return err
+		}
+	}
+	if err := openRevealPhase(ctx, escrow); err != nil {
 		return err
 	}
 
-	return nil
+	return s.recordEscrow(ctx, EventDeliveryConfirmed, &old, escrow)
 }
 
-// receiver runs this to verify with manufacture verify (in asset) and sender (in escrowContract) [invoke]
-func (s *SmartContract) VerifyProduct(ctx contractapi.TransactionContextInterface, txn string, bValue string) error {
-	escrow, err := ctx.GetStub().GetState(txn)
-	var escrowJSON EscrowContract
-	err = json.Unmarshal(escrow, &escrowJSON)
-	
+// VerifyProduct settles the verification once both sender and receiver have
+// revealed, or after the reveal deadline with whatever was revealed. A missing
+// reveal is charged to the party that missed it, see revealFinding. Any party
+// of the escrow can run it. [invoke]
+// DELIVERED -> VERIFIED | DISPUTED
+func (s *SmartContract) VerifyProduct(ctx contractapi.TransactionContextInterface, txn string) error {
+	escrow, err := s.readEscrow(ctx, txn)
 	if err != nil {
-		return fmt.Errorf("failed to read from world state: %v", err)
+		return err
 	}
-
-	asset, err := ctx.GetStub().GetState(escrowJSON.AssetID)
-	var assetJSON Asset
-	err = json.Unmarshal(asset, &assetJSON)
-	
+	x, err := ctx.GetClientIdentity().GetMSPID()
 	if err != nil {
-		return fmt.Errorf("failed to read from world state: %v", err)
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+	if x != escrow.Sender && x != escrow.Receiver && x != escrow.Delivery {
+		return fmt.Errorf("only a party of escrow %s can settle verification", txn)
 	}
-	x, _ := ctx.GetClientIdentity().GetMSPID()
-	if x != escrowJSON.Receiver {
-		return fmt.Errorf("Only receiver (B) can start the delivery")
+	if err := requireActiveStakeholder(ctx, x); err != nil {
+		return err
 	}
-	if escrowJSON.TransactionCompleted {
-		return fmt.Errorf("Transaction is already completed")
-	}	
-	if escrowJSON.DisputeFlag {
-		return fmt.Errorf("Dispute is ongoing")
+	if escrow.Status != StatusDelivered {
+		return fmt.Errorf("escrow %s is %s, verification needs %s", txn, escrow.Status, StatusDelivered)
 	}
 
-	aValue := escrowJSON.Verify
-	originalValue := assetJSON.VerifyValue
-	// Compare values with the original manufacturer values
-	if originalValue == aValue && aValue == bValue {
-		escrowJSON.TransactionCompleted = true
-	} else if aValue == originalValue && bValue != aValue {
-		// D is malicious, delivery stake to A, return escrow to B, and flag D
-		escrowJSON.DisputeFlag = true
-		escrowJSON.TransactionCompleted = false
-	} else {
-		// A is malicious, refund delivery stake to D, return escrow to B, and flag A
-		escrowJSON.DisputeFlag = true
-		escrowJSON.TransactionCompleted = false
+	if escrow.SenderReveal == "" || escrow.ReceiverReveal == "" {
+		now, err := txTimestamp(ctx)
+		if err != nil {
+			return err
+		}
+		if escrow.RevealDeadline == 0 || now <= escrow.RevealDeadline {
+			return fmt.Errorf("escrow %s is still waiting for reveals", txn)
+		}
 	}
-	assetJSON.Owner, _ = ctx.GetClientIdentity().GetMSPID() // new owner is receiver
-	escrow, err = json.Marshal(escrowJSON)
-	err = ctx.GetStub().PutState(txn, escrow)
+
+	return s.settleVerification(ctx, escrow)
+}
+
+// settleVerification compares the revealed values with the manufacturer's and
+// settles with the matching processFlow.sol verifyProduct outcome.
+func (s *SmartContract) settleVerification(ctx contractapi.TransactionContextInterface, escrow *EscrowContract) error {
+	if len(escrow.Lines) > 0 {
+		return s.settleOrderVerification(ctx, escrow)
+	}
+	originalValue, err := s.expectedVerifyHash(ctx, escrow)
 	if err != nil {
 		return err
 	}
-	asset, err = json.Marshal(assetJSON)
-	err = ctx.GetStub().PutState(txn, asset)
+
+	if len(escrow.Serials) > 0 {
+		findings, err := s.unitFindings(ctx, escrow)
+		if err != nil {
+			return err
+		}
+		escrow.UnitFindings = findings
+	}
+
+	compromised, err := s.escrowCompromised(ctx, escrow)
 	if err != nil {
 		return err
 	}
-	return fmt.Errorf("Product verified and is authentic. Owner is now %s", x)
+	if compromised {
+		// confirmed counterfeit, no value can verify it
+		return s.settleOutcome(ctx, escrow, OutcomeSenderAtFault)
+	}
+
+	return s.settleOutcome(ctx, escrow, revealFinding(originalValue, escrow.SenderReveal, escrow.ReceiverReveal))
+}
+
+// revealFinding settles missed reveals before comparing values, so that
+// silence is never read as a mismatch blaming someone else. Without A's value
+// A is at fault; without B's value B accepts the goods if A's value matches.
+func revealFinding(originalValue, aValue, bValue string) SettlementOutcome {
+	if aValue == "" {
+		return OutcomeSenderAtFault
+	}
+	if bValue == "" {
+		if aValue == originalValue {
+			return OutcomeVerifyTimeout
+		}
+		return OutcomeSenderAtFault
+	}
+	return verificationFinding(originalValue, aValue, bValue)
+}
+
+// verificationFinding is the processFlow.sol verifyProduct comparison of A's
+// and B's values with the manufacturer's.
+func verificationFinding(originalValue, aValue, bValue string) SettlementOutcome {
+	// Compare values with the original manufacturer values
+	outcome := OutcomeSenderAtFault // A is malicious, refund delivery stake to D, return escrow to B, and flag A
+	if originalValue == aValue && aValue == bValue {
+		outcome = OutcomeAllMatch // release escrow to A and delivery stake back to D
+	} else if aValue == originalValue && bValue != aValue {
+		outcome = OutcomeCarrierAtFault // D is malicious, delivery stake to A, return escrow to B, and flag D
+	}
+	return outcome
 }
@@ -0,0 +1,376 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// A manufacturer recalls a bad batch with RecallAssets. Recalled lots and
+// units can no longer be transferred, split, assembled or sold through a new
+// escrow. Escrows already open on them are put on RECALL_HOLD with their funds
+// locked until a party calls ReleaseRecallHold, which refunds the receiver.
+
+// Recall scopes accepted by RecallAssets.
+const (
+	RecallScopeSerial   = "serial"   // one registered unit
+	RecallScopeLot      = "lot"      // a minted lot and every lot split from or merged with it
+	RecallScopeChipName = "chipName" // every lot of that chip name the caller minted
+)
+
+var recallSeverities = map[string]bool{
+	"LOW":      true,
+	"MEDIUM":   true,
+	"HIGH":     true,
+	"CRITICAL": true,
+}
+
+type Recall struct {
+	Assets       []string `json:"assets"`      // lots flagged by this recall
+	HeldEscrows  []string `json:"heldEscrows"` // open escrows moved to RECALL_HOLD
+	ID           string   `json:"id"`          // txn ID of the RecallAssets call
+	Manufacturer string   `json:"manufacturer"`
+	Reason       string   `json:"reason"`
+	Scope        string   `json:"scope"`
+	Severity     string   `json:"severity"`
+	Timestamp    int64    `json:"timestamp"`
+	Units        []string `json:"units"` // serials flagged on their own
+	Value        string   `json:"value"` // the serial, lot ID or chip name recalled
+}
+
+// RecallOwner is one holder of recalled goods.
+type RecallOwner struct {
+	Assets []string `json:"assets"`
+	Owner  string   `json:"owner"`
+	Units  []string `json:"units"`
+}
+
+func readRecall(ctx contractapi.TransactionContextInterface, id string) (*Recall, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(recallObjectType, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	recallJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if recallJSON == nil {
+		return nil, fmt.Errorf("the recall %s does not exist", id)
+	}
+
+	var recall Recall
+	err = json.Unmarshal(recallJSON, &recall)
+	if err != nil {
+		return nil, err
+	}
+	return &recall, nil
+}
+
+func putRecall(ctx contractapi.TransactionContextInterface, recall *Recall) error {
+	key, err := ctx.GetStub().CreateCompositeKey(recallObjectType, []string{recall.ID})
+	if err != nil {
+		return err
+	}
+	recallJSON, err := json.Marshal(recall)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, recallJSON)
+}
+
+// assetEscrows returns every escrow ever drafted on a lot.
+func (s *SmartContract) assetEscrows(ctx contractapi.TransactionContextInterface, assetID string) ([]*EscrowContract, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(escrowAssetIndex, []string{assetID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var escrows []*EscrowContract
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		escrow, err := s.readEscrow(ctx, attributes[1])
+		if err != nil {
+			return nil, err
+		}
+		escrows = append(escrows, escrow)
+	}
+	return escrows, nil
+}
+
+// recallTargets resolves a recall scope to the lots and units it flags.
+func (s *SmartContract) recallTargets(ctx contractapi.TransactionContextInterface, manufacturer, scope, value string) ([]*Asset, []*Unit, error) {
+	switch scope {
+	case RecallScopeSerial:
+		unit, err := readUnit(ctx, value)
+		if err != nil {
+			return nil, nil, err
+		}
+		lot, err := s.ReadAsset(ctx, unit.LotID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if lot.Manufacturer != manufacturer {
+			return nil, nil, fmt.Errorf("only %s, which minted unit %s, can recall it", lot.Manufacturer, value)
+		}
+		return nil, []*Unit{unit}, nil
+
+	case RecallScopeLot:
+		lot, err := s.ReadAsset(ctx, value)
+		if err != nil {
+			return nil, nil, err
+		}
+		if lot.Manufacturer != manufacturer {
+			return nil, nil, fmt.Errorf("only %s, which minted lot %s, can recall it", lot.Manufacturer, value)
+		}
+		resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(manufacturerIndex, []string{manufacturer})
+		if err != nil {
+			return nil, nil, err
+		}
+		defer resultsIterator.Close()
+		minted, err := s.assetsFromIndex(ctx, resultsIterator)
+		if err != nil {
+			return nil, nil, err
+		}
+		// split and merged lots share the verification hash of the lot they came from
+		var lots []*Asset
+		for _, asset := range minted {
+			if asset.VerifyHash == lot.VerifyHash {
+				lots = append(lots, asset)
+			}
+		}
+		return lots, nil, nil
+
+	case RecallScopeChipName:
+		resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(chipNameIndex, []string{value})
+		if err != nil {
+			return nil, nil, err
+		}
+		defer resultsIterator.Close()
+		named, err := s.assetsFromIndex(ctx, resultsIterator)
+		if err != nil {
+			return nil, nil, err
+		}
+		var lots []*Asset
+		for _, asset := range named {
+			if asset.Manufacturer == manufacturer {
+				lots = append(lots, asset)
+			}
+		}
+		if lots == nil {
+			return nil, nil, fmt.Errorf("%s minted no %s lots", manufacturer, value)
+		}
+		return lots, nil, nil
+	}
+	return nil, nil, fmt.Errorf("unknown recall scope %q, expected %s, %s or %s", scope, RecallScopeSerial, RecallScopeLot, RecallScopeChipName)
+}
+
+// RecallAssets flags the caller's serial, lot or chip name as recalled and
+// puts the open escrows on it on RECALL_HOLD. Only the minting manufacturer
+// can recall. severity is LOW, MEDIUM, HIGH or CRITICAL. [invoke]
+func (s *SmartContract) RecallAssets(ctx contractapi.TransactionContextInterface, scope, value, reason, severity string) (*Recall, error) {
+	x, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client identity: %v", err)
+	}
+	if !recallSeverities[severity] {
+		return nil, fmt.Errorf("unknown severity %q, expected LOW, MEDIUM, HIGH or CRITICAL", severity)
+	}
+	if reason == "" {
+		return nil, fmt.Errorf("a recall needs a reason")
+	}
+	lots, units, err := s.recallTargets(ctx, x, scope, value)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	recall := Recall{
+		Assets:       []string{},
+		HeldEscrows:  []string{},
+		ID:           ctx.GetStub().GetTxID(),
+		Manufacturer: x,
+		Reason:       reason,
+		Scope:        scope,
+		Severity:     severity,
+		Timestamp:    now,
+		Units:        []string{},
+		Value:        value,
+	}
+	for _, lot := range lots {
+		if lot.RecallID != "" {
+			continue
+		}
+		lot.RecallID = recall.ID
+		if err := s.putAsset(ctx, lot); err != nil {
+			return nil, err
+		}
+		recall.Assets = append(recall.Assets, lot.ChipID)
+	}
+	for _, unit := range units {
+		if unit.RecallID != "" {
+			continue
+		}
+		unit.RecallID = recall.ID
+		if err := putUnit(ctx, unit); err != nil {
+			return nil, err
+		}
+		recall.Units = append(recall.Units, unit.Serial)
+	}
+	if len(recall.Assets) == 0 && len(recall.Units) == 0 {
+		return nil, fmt.Errorf("%s %s is already recalled", scope, value)
+	}
+
+	// every escrow on a recalled lot is held; for a unit, only escrows naming it
+	held := map[string][]string{}
+	for _, lotID := range recall.Assets {
+		held[lotID] = nil
+	}
+	for _, unit := range units {
+		held[unit.LotID] = append(held[unit.LotID], unit.Serial)
+	}
+	lotIDs := make([]string, 0, len(held))
+	for lotID := range held {
+		lotIDs = append(lotIDs, lotID)
+	}
+	sort.Strings(lotIDs)
+	// an order escrow can cover several recalled lots; the ledger still shows
+	// it unheld on the second one, so remember what this recall has held
+	heldEscrows := map[string]bool{}
+	for _, lotID := range lotIDs {
+		escrows, err := s.assetEscrows(ctx, lotID)
+		if err != nil {
+			return nil, err
+		}
+		for _, escrow := range escrows {
+			if heldEscrows[escrow.TxnID] || !canTransition(escrow.Status, StatusRecallHold) || !namesAny(escrow, held[lotID]) {
+				continue
+			}
+			heldEscrows[escrow.TxnID] = true
+			if err := escrow.transition(StatusRecallHold); err != nil {
+				return nil, err
+			}
+			if err := s.putEscrow(ctx, escrow); err != nil {
+				return nil, err
+			}
+			recall.HeldEscrows = append(recall.HeldEscrows, escrow.TxnID)
+		}
+	}
+
+	if err := putRecall(ctx, &recall); err != nil {
+		return nil, err
+	}
+	return &recall, emitEvent(ctx, EventAssetsRecalled, recall.ID, nil, &recall)
+}
+
+// namesAny reports whether the escrow covers one of serials. A nil list
+// stands for the whole lot, which every escrow on it covers.
+func namesAny(escrow *EscrowContract, serials []string) bool {
+	if serials == nil || len(escrow.Serials) == 0 {
+		return true
+	}
+	for _, named := range escrow.Serials {
+		for _, serial := range serials {
+			if named == serial {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReleaseRecallHold closes an escrow held by a recall: the receiver gets its
+// escrow amount back and the carrier its stake. Any party of the escrow can
+// call it. [invoke]
+// RECALL_HOLD -> CANCELLED
+func (s *SmartContract) ReleaseRecallHold(ctx contractapi.TransactionContextInterface, txn string) error {
+	escrow, err := s.readEscrow(ctx, txn)
+	if err != nil {
+		return err
+	}
+	x, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+	if x != escrow.Sender && x != escrow.Receiver && x != escrow.Delivery {
+		return fmt.Errorf("only a party of escrow %s can release its recall hold", txn)
+	}
+	if escrow.Status != StatusRecallHold {
+		return fmt.Errorf("escrow %s is %s, not %s", txn, escrow.Status, StatusRecallHold)
+	}
+	return s.settleOutcome(ctx, escrow, OutcomeRecalled)
+}
+
+// GetRecall returns a recall record. [query]
+func (s *SmartContract) GetRecall(ctx contractapi.TransactionContextInterface, id string) (*Recall, error) {
+	return readRecall(ctx, id)
+}
+
+// GetRecallOwners lists who currently holds the goods a recall flagged, so
+// they can be contacted. Units of a recalled lot held apart from it are
+// listed under their own holder. [query]
+func (s *SmartContract) GetRecallOwners(ctx contractapi.TransactionContextInterface, id string) ([]*RecallOwner, error) {
+	recall, err := readRecall(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	owners := map[string]*RecallOwner{}
+	holder := func(owner string) *RecallOwner {
+		if owners[owner] == nil {
+			owners[owner] = &RecallOwner{Assets: []string{}, Owner: owner, Units: []string{}}
+		}
+		return owners[owner]
+	}
+	var units []*Unit
+	for _, lotID := range recall.Assets {
+		lot, err := s.ReadAsset(ctx, lotID)
+		if err != nil {
+			// merged away since the recall, its chips are in the target lot
+			continue
+		}
+		holder(lot.Owner).Assets = append(holder(lot.Owner).Assets, lotID)
+		lotMembers, err := lotUnits(ctx, lotID)
+		if err != nil {
+			return nil, err
+		}
+		for _, unit := range lotMembers {
+			if unit.Owner != lot.Owner {
+				units = append(units, unit)
+			}
+		}
+	}
+	for _, serial := range recall.Units {
+		unit, err := readUnit(ctx, serial)
+		if err != nil {
+			return nil, err
+		}
+		units = append(units, unit)
+	}
+	for _, unit := range units {
+		holder(unit.Owner).Units = append(holder(unit.Owner).Units, unit.Serial)
+	}
+
+	result := make([]*RecallOwner, 0, len(owners))
+	for _, owner := range owners {
+		result = append(result, owner)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Owner < result[j].Owner })
+	return result, nil
+}
@@ -0,0 +1,76 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRecallHoldsAnOrderOnce(t *testing.T) {
+	s := &SmartContract{}
+	ctx := newTestContext("Org1MSP")
+	escrow := orderFixture(t, s, ctx, 100, 200)
+	for _, id := range []string{"lotA", "lotB"} {
+		lot, err := s.ReadAsset(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lot.ChipName = "chip"
+		lot.Manufacturer = "Org1MSP"
+		if err := s.putAsset(ctx, lot); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.putEscrow(ctx, escrow); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+
+	recall, err := s.RecallAssets(ctx, RecallScopeChipName, "chip", "bad batch", "HIGH")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+
+	if !reflect.DeepEqual(recall.HeldEscrows, []string{"order1"}) {
+		t.Errorf("held escrows: got %v, want [order1]", recall.HeldEscrows)
+	}
+	requireEscrowStatus(t, s, ctx, "order1", StatusRecallHold)
+}
+
+func TestUnitRecallHoldsOnlyEscrowsNamingIt(t *testing.T) {
+	s := &SmartContract{}
+	ctx := newTestContext("Org1MSP")
+	lot := &Asset{ChipID: "lot1", DocType: "asset", Manufacturer: "Org1MSP", Owner: "Org1MSP", Quantity: 2}
+	if err := s.putAsset(ctx, lot); err != nil {
+		t.Fatal(err)
+	}
+	for _, serial := range []string{"s1", "s2"} {
+		if err := putUnit(ctx, &Unit{LotID: "lot1", Owner: "Org1MSP", Serial: serial}); err != nil {
+			t.Fatal(err)
+		}
+		escrow := &EscrowContract{
+			AssetID:  "lot1",
+			Receiver: "Org2MSP",
+			Sender:   "Org1MSP",
+			Serials:  []string{serial},
+			Status:   StatusFunded,
+			TxnID:    "escrow-" + serial,
+		}
+		if err := s.putEscrow(ctx, escrow); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx.stub.commit()
+
+	if _, err := s.RecallAssets(ctx, RecallScopeSerial, "s1", "bad unit", "LOW"); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+
+	requireEscrowStatus(t, s, ctx, "escrow-s1", StatusRecallHold)
+	requireEscrowStatus(t, s, ctx, "escrow-s2", StatusFunded)
+}
@@ -0,0 +1,166 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Reputation is the per-organisation track record that processFlow.sol only
+// described as "flag A" / "flag D". It is updated whenever an escrow settles.
+type Reputation struct {
+	CompletedDeliveries uint64 `json:"completedDeliveries"` // escrows closed with the goods accepted
+	DisputesLost        uint64 `json:"disputesLost"`        // rulings against this org
+	MissedDeliveries    uint64 `json:"missedDeliveries"`    // deliveries or order lines not made as carrier
+	MSPID               string `json:"mspID"`
+	StakesForfeited     uint64 `json:"stakesForfeited"` // delivery stakes paid to the sender, by a ruling or a missed delivery
+	Suspended           bool   `json:"suspended"`       // computed on read from the penalty threshold
+}
+
+// penalty is the score compared against Config.PenaltyThreshold: one point per
+// ruling lost and per missed delivery. A forfeited stake comes with the ruling
+// or missed delivery that forfeits it, so StakesForfeited is not scored a
+// second time.
+func (r *Reputation) penalty() uint64 {
+	return r.DisputesLost + r.MissedDeliveries
+}
+
+func readReputation(ctx contractapi.TransactionContextInterface, mspID string) (*Reputation, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(reputationObjectType, []string{mspID})
+	if err != nil {
+		return nil, err
+	}
+	reputationJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	reputation := Reputation{MSPID: mspID}
+	if reputationJSON == nil {
+		return &reputation, nil
+	}
+
+	err = json.Unmarshal(reputationJSON, &reputation)
+	if err != nil {
+		return nil, err
+	}
+	return &reputation, nil
+}
+
+func putReputation(ctx contractapi.TransactionContextInterface, reputation *Reputation) error {
+	key, err := ctx.GetStub().CreateCompositeKey(reputationObjectType, []string{reputation.MSPID})
+	if err != nil {
+		return err
+	}
+	reputation.Suspended = false // derived, never stored
+	reputationJSON, err := json.Marshal(reputation)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, reputationJSON)
+}
+
+// recordReputation updates the parties' records for a settled escrow.
+func recordReputation(ctx contractapi.TransactionContextInterface, escrow *EscrowContract, outcome SettlementOutcome) error {
+	update := func(mspID string, apply func(*Reputation)) error {
+		reputation, err := readReputation(ctx, mspID)
+		if err != nil {
+			return err
+		}
+		apply(reputation)
+		return putReputation(ctx, reputation)
+	}
+
+	switch outcome {
+	case OutcomeAllMatch, OutcomeVerifyTimeout, OutcomeRuledNoFault, OutcomeRuledSplit:
+		// an org holding two roles (see SetPartyPolicy) completed one
+		// delivery, and a second update would overwrite the first anyway
+		counted := map[string]bool{}
+		for _, party := range []string{escrow.Sender, escrow.Receiver, escrow.Delivery} {
+			if counted[party] {
+				continue
+			}
+			counted[party] = true
+			if err := update(party, func(r *Reputation) { r.CompletedDeliveries++ }); err != nil {
+				return err
+			}
+		}
+	case OutcomeRuledCarrierAtFault:
+		// flag D, its stake went to A
+		return update(escrow.Delivery, func(r *Reputation) {
+			r.DisputesLost++
+			r.StakesForfeited++
+		})
+	case OutcomeRuledSenderAtFault:
+		// flag A (potentially ban from the network)
+		return update(escrow.Sender, func(r *Reputation) { r.DisputesLost++ })
+	case OutcomeDeliveryTimeout:
+		// its stake, or the missed lines' share of it, went to A
+		return update(escrow.Delivery, func(r *Reputation) {
+			r.MissedDeliveries++
+			r.StakesForfeited++
+		})
+	}
+	return nil
+}
+
+// isSuspended reports whether the org's penalty score reached the configured threshold.
+func isSuspended(config *Config, reputation *Reputation) bool {
+	return config.PenaltyThreshold > 0 && reputation.penalty() >= config.PenaltyThreshold
+}
+
+// requireGoodStanding fails when mspID is suspended by the penalty rule.
+func requireGoodStanding(ctx contractapi.TransactionContextInterface, mspID string) error {
+	config, err := readConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return fmt.Errorf("ledger is not initialised, run InitLedger first")
+	}
+	reputation, err := readReputation(ctx, mspID)
+	if err != nil {
+		return err
+	}
+	if isSuspended(config, reputation) {
+		return fmt.Errorf("%s is suspended: penalty score %d reached threshold %d", mspID, reputation.penalty(), config.PenaltyThreshold)
+	}
+	return nil
+}
+
+// SetPenaltyThreshold sets the disputes lost plus missed deliveries at which an
+// org can no longer take part in new escrows. 0 disables suspension. Admin only. [invoke]
+func (s *SmartContract) SetPenaltyThreshold(ctx contractapi.TransactionContextInterface, threshold uint64) error {
+	if _, err := requireAdmin(ctx); err != nil {
+		return err
+	}
+	config, err := readConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	config.PenaltyThreshold = threshold
+	return putConfig(ctx, config)
+}
+
+// GetReputation returns an org's track record so partners can be vetted
+// before contracting. [query]
+func (s *SmartContract) GetReputation(ctx contractapi.TransactionContextInterface, mspID string) (*Reputation, error) {
+	config, err := readConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, fmt.Errorf("ledger is not initialised, run InitLedger first")
+	}
+	reputation, err := readReputation(ctx, mspID)
+	if err != nil {
+		return nil, err
+	}
+	reputation.Suspended = isSuspended(config, reputation)
+	return reputation, nil
+}
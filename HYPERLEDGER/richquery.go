@@ -0,0 +1,142 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Rich queries need CouchDB as the state database. Selectors are always built
+// here from typed filters and marshalled with encoding/json, never taken from
+// the client, so a caller cannot widen a query beyond the fields below. The
+// matching indexes ship in META-INF/statedb/couchdb/indexes.
+
+// EscrowQueryResult is one page of escrows plus the bookmark for the next page.
+type EscrowQueryResult struct {
+	Bookmark            string            `json:"bookmark"`
+	FetchedRecordsCount int32             `json:"fetchedRecordsCount"`
+	Records             []*EscrowContract `json:"records"`
+}
+
+// EscrowOpen is the pseudo status QueryMyEscrows accepts for "any non-final status".
+const EscrowOpen = "OPEN"
+
+// QueryAssets returns one page of assets matching every non-empty filter:
+// exact owner, chip name prefix and exact manufacturer. [query]
+func (s *SmartContract) QueryAssets(ctx contractapi.TransactionContextInterface, owner, chipNamePrefix, manufacturer string, pageSize int32, bookmark string) (*PaginatedQueryResult, error) {
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("page size must be positive")
+	}
+	selector := map[string]interface{}{"docType": assetObjectType}
+	if owner != "" {
+		selector["owner"] = owner
+	}
+	if chipNamePrefix != "" {
+		// a range rather than $regex so CouchDB can use the chipName index
+		selector["chipName"] = map[string]string{"$gte": chipNamePrefix, "$lt": chipNamePrefix + "\ufff0"}
+	}
+	if manufacturer != "" {
+		selector["manufacturer"] = manufacturer
+	}
+	queryString, err := json.Marshal(map[string]interface{}{"selector": selector})
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetQueryResultWithPagination(string(queryString), pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var assets []*Asset
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var asset Asset
+		err = json.Unmarshal(queryResponse.Value, &asset)
+		if err != nil {
+			return nil, err
+		}
+		assets = append(assets, &asset)
+	}
+
+	return &PaginatedQueryResult{
+		Bookmark:            metadata.Bookmark,
+		FetchedRecordsCount: metadata.FetchedRecordsCount,
+		Records:             assets,
+	}, nil
+}
+
+// QueryMyEscrows returns one page of escrows where the caller's org plays role
+// ("sender", "receiver" or "delivery"). status filters on an exact EscrowStatus,
+// EscrowOpen for any non-final status, or "" for all. [query]
+func (s *SmartContract) QueryMyEscrows(ctx contractapi.TransactionContextInterface, role, status string, pageSize int32, bookmark string) (*EscrowQueryResult, error) {
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("page size must be positive")
+	}
+	switch role {
+	case "sender", "receiver", "delivery":
+	default:
+		return nil, fmt.Errorf("unknown role %q, expected sender, receiver or delivery", role)
+	}
+	x, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client identity: %v", err)
+	}
+
+	selector := map[string]interface{}{"docType": escrowObjectType, role: x}
+	switch status {
+	case "":
+	case EscrowOpen:
+		var open []EscrowStatus
+		for from := range escrowTransitions {
+			open = append(open, from)
+		}
+		// map order is random, keep the query string identical on every endorser
+		sort.Slice(open, func(i, j int) bool { return open[i] < open[j] })
+		selector["status"] = map[string]interface{}{"$in": open}
+	default:
+		selector["status"] = status
+	}
+	queryString, err := json.Marshal(map[string]interface{}{"selector": selector})
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetQueryResultWithPagination(string(queryString), pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var escrows []*EscrowContract
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var escrow EscrowContract
+		err = json.Unmarshal(queryResponse.Value, &escrow)
+		if err != nil {
+			return nil, err
+		}
+		escrows = append(escrows, &escrow)
+	}
+
+	return &EscrowQueryResult{
+		Bookmark:            metadata.Bookmark,
+		FetchedRecordsCount: metadata.FetchedRecordsCount,
+		Records:             escrows,
+	}, nil
+}
@@ -0,0 +1,84 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Verification values are authentication secrets. They only ever arrive in
+// the transient map (never in transaction args, which are recorded in the
+// block) and only a salted hash is written to public world state. The
+// manufacturer's cleartext is kept in its implicit org collection together
+// with the salt: Fabric publishes the hash of every private value on the
+// ledger, and an unsalted value could be guessed back from it.
+
+// transientVerifyKey is the transient map entry carrying a verification value.
+const transientVerifyKey = "verifyValue"
+
+// verifySecretKey is the private data key for an asset's cleartext value.
+const verifySecretKey = "verify"
+
+// verifySecret is the private record of a verification value.
+type verifySecret struct {
+	Salt  string `json:"salt"` // the asset's or unit's VerifySalt
+	Value string `json:"value"`
+}
+
+// implicitCollection is the Fabric 2.x per-org private data collection,
+// readable only by peers of that org.
+func implicitCollection(mspID string) string {
+	return "_implicit_org_" + mspID
+}
+
+// hashVerifyValue returns the hex SHA-256 of salt and value.
+func hashVerifyValue(salt, value string) string {
+	sum := sha256.Sum256([]byte(salt + "\x00" + value))
+	return hex.EncodeToString(sum[:])
+}
+
+// transientVerifyValue reads the caller's verification value from the transient map.
+func transientVerifyValue(ctx contractapi.TransactionContextInterface) (string, error) {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return "", fmt.Errorf("failed to read transient map: %v", err)
+	}
+	value, ok := transientMap[transientVerifyKey]
+	if !ok || len(value) == 0 {
+		return "", fmt.Errorf("%s must be supplied in the transient map", transientVerifyKey)
+	}
+	return string(value), nil
+}
+
+// putVerifySecret stores the manufacturer's cleartext value in its own collection.
+func putVerifySecret(ctx contractapi.TransactionContextInterface, manufacturer, assetID, salt, value string) error {
+	key, err := ctx.GetStub().CreateCompositeKey(verifySecretKey, []string{assetID})
+	if err != nil {
+		return err
+	}
+	return putSecret(ctx, manufacturer, key, salt, value)
+}
+
+// putUnitVerifySecret stores a unit's cleartext value next to its lot's.
+func putUnitVerifySecret(ctx contractapi.TransactionContextInterface, manufacturer, lotID, serial, salt, value string) error {
+	key, err := ctx.GetStub().CreateCompositeKey(verifySecretKey, []string{lotID, serial})
+	if err != nil {
+		return err
+	}
+	return putSecret(ctx, manufacturer, key, salt, value)
+}
+
+func putSecret(ctx contractapi.TransactionContextInterface, manufacturer, key, salt, value string) error {
+	secretJSON, err := json.Marshal(verifySecret{Salt: salt, Value: value})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutPrivateData(implicitCollection(manufacturer), key, secretJSON)
+}
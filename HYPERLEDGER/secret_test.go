@@ -0,0 +1,32 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestVerifySecretIsStoredWithItsSalt(t *testing.T) {
+	ctx := newTestContext("Org1MSP")
+	if err := putVerifySecret(ctx, "Org1MSP", "lotA", "salt", "value"); err != nil {
+		t.Fatal(err)
+	}
+	key, err := ctx.stub.CreateCompositeKey(verifySecretKey, []string{"lotA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secretJSON, err := ctx.stub.GetPrivateData(implicitCollection("Org1MSP"), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var secret verifySecret
+	if err := json.Unmarshal(secretJSON, &secret); err != nil {
+		t.Fatal(err)
+	}
+	if secret != (verifySecret{Salt: "salt", Value: "value"}) {
+		t.Errorf("secret: got %+v, want salt and value", secret)
+	}
+}
@@ -0,0 +1,161 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// SettlementOutcome records why an escrow closed and decides who is paid.
+type SettlementOutcome string
+
+const (
+	OutcomeAllMatch        SettlementOutcome = "ALL_MATCH"        // A, B and manufacturer values agree
+	OutcomeCarrierAtFault  SettlementOutcome = "CARRIER_AT_FAULT" // A matched, B did not: dispute, D suspected
+	OutcomeSenderAtFault   SettlementOutcome = "SENDER_AT_FAULT"  // A did not match: dispute, A suspected
+	OutcomeFundingTimeout  SettlementOutcome = "FUNDING_TIMEOUT"  // B never funded
+	OutcomeShippingTimeout SettlementOutcome = "SHIPPING_TIMEOUT" // A never handed over
+	OutcomeDeliveryTimeout SettlementOutcome = "DELIVERY_TIMEOUT" // D never delivered, stake forfeited to A
+	OutcomeVerifyTimeout   SettlementOutcome = "VERIFY_TIMEOUT"   // B never committed, goods accepted
+	OutcomeCancelled       SettlementOutcome = "CANCELLED"        // A withdrew before funding
+	OutcomeMutualAbort     SettlementOutcome = "MUTUAL_ABORT"     // A and B both agreed to abort
+	OutcomeRecalled        SettlementOutcome = "RECALLED"         // goods recalled by their manufacturer
+
+	// Dispute rulings, see IssueRuling.
+	OutcomeRuledCarrierAtFault SettlementOutcome = "RULED_CARRIER_AT_FAULT" // stake to A, escrow back to B
+	OutcomeRuledSenderAtFault  SettlementOutcome = "RULED_SENDER_AT_FAULT"  // stake back to D, escrow back to B
+	OutcomeRuledNoFault        SettlementOutcome = "RULED_NO_FAULT"         // settles as if verified
+	OutcomeRuledSplit          SettlementOutcome = "RULED_SPLIT"            // escrow halved between A and B, B keeps goods
+)
+
+// settlement is one row of the payout table.
+type settlement struct {
+	status     EscrowStatus
+	amountTo   string // "sender", "receiver" or "delivery"
+	stakeTo    string
+	toReceiver bool // asset ownership moves to the receiver
+	split      bool // half the escrow amount goes to the sender, the rest to amountTo
+}
+
+// settlements mirrors processFlow.sol verifyProduct for the verification
+// outcomes and extends it to the deadline and ruling outcomes. Outcomes that
+// lead to DISPUTED pay nothing: funds stay locked until a ruling.
+var settlements = map[SettlementOutcome]settlement{
+	OutcomeAllMatch:            {StatusVerified, "sender", "delivery", true, false},
+	OutcomeVerifyTimeout:       {StatusVerified, "sender", "delivery", true, false},
+	OutcomeCarrierAtFault:      {StatusDisputed, "", "", false, false},
+	OutcomeSenderAtFault:       {StatusDisputed, "", "", false, false},
+	OutcomeFundingTimeout:      {StatusExpired, "receiver", "delivery", false, false},
+	OutcomeShippingTimeout:     {StatusExpired, "receiver", "delivery", false, false},
+	OutcomeDeliveryTimeout:     {StatusExpired, "receiver", "sender", false, false},
+	OutcomeCancelled:           {StatusCancelled, "receiver", "delivery", false, false},
+	OutcomeMutualAbort:         {StatusCancelled, "receiver", "delivery", false, false},
+	OutcomeRecalled:            {StatusCancelled, "receiver", "delivery", false, false},
+	OutcomeRuledCarrierAtFault: {StatusResolved, "receiver", "sender", false, false},
+	OutcomeRuledSenderAtFault:  {StatusResolved, "receiver", "delivery", false, false},
+	OutcomeRuledNoFault:        {StatusResolved, "sender", "delivery", true, false},
+	OutcomeRuledSplit:          {StatusResolved, "receiver", "delivery", true, true},
+}
+
+// hasParty reports whether mspID is sender, receiver or carrier of the escrow.
+func (e *EscrowContract) hasParty(mspID string) bool {
+	return mspID == e.Sender || mspID == e.Receiver || mspID == e.Delivery
+}
+
+// party maps a settlement role to the escrow's MSP ID.
+func (e *EscrowContract) party(role string) string {
+	switch role {
+	case "sender":
+		return e.Sender
+	case "receiver":
+		return e.Receiver
+	default:
+		return e.Delivery
+	}
+}
+
+// settleOutcome closes the escrow: it moves the status, pays out the locked
+// funds and, when the goods were accepted, hands the asset (or the units the
+// escrow names) to the receiver. A DISPUTED outcome instead keeps the funds
+// locked and opens a dispute.
+func (s *SmartContract) settleOutcome(ctx contractapi.TransactionContextInterface, escrow *EscrowContract, outcome SettlementOutcome) error {
+	row, ok := settlements[outcome]
+	if !ok {
+		return fmt.Errorf("unknown settlement outcome %s", outcome)
+	}
+	old := *escrow
+	if err := escrow.transition(row.status); err != nil {
+		return err
+	}
+	escrow.Outcome = outcome
+	if row.status == StatusDisputed {
+		if err := openDispute(ctx, escrow); err != nil {
+			return err
+		}
+		return s.recordEscrow(ctx, EventEscrowDisputed, &old, escrow)
+	}
+
+	if row.split {
+		half := escrow.LockedAmount / 2
+		if err := escrow.pay(escrow.Sender, half); err != nil {
+			return err
+		}
+		escrow.LockedAmount -= half
+	}
+	if err := escrow.releaseFunds(escrow.party(row.amountTo), escrow.party(row.stakeTo)); err != nil {
+		return err
+	}
+	if err := recordReputation(ctx, escrow, outcome); err != nil {
+		return err
+	}
+	if row.toReceiver {
+		if err := s.deliverGoods(ctx, escrow, outcome); err != nil {
+			return err
+		}
+	}
+	return s.recordEscrow(ctx, EventEscrowSettled, &old, escrow)
+}
+
+// deliverGoods hands what the escrow sold to the receiver: the named units,
+// the order lines still held, or the whole lot.
+func (s *SmartContract) deliverGoods(ctx contractapi.TransactionContextInterface, escrow *EscrowContract, outcome SettlementOutcome) error {
+	if len(escrow.Lines) > 0 {
+		escrow.copyLines()
+		for i := range escrow.Lines {
+			line := &escrow.Lines[i]
+			if line.Status != LineDelivered && line.Status != LineFailed {
+				continue
+			}
+			if err := s.deliverLine(ctx, escrow, line, outcome); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	asset, err := s.ReadAsset(ctx, escrow.AssetID)
+	if err != nil {
+		return err
+	}
+	if len(escrow.Serials) > 0 {
+		// only the named units change hands, the rest of the lot stays with A
+		var units []*Unit
+		for _, serial := range escrow.Serials {
+			unit, err := readUnit(ctx, serial)
+			if err != nil {
+				return err
+			}
+			units = append(units, unit)
+		}
+		return s.carveUnits(ctx, asset, units, asset.ChipID+"-"+escrow.TxnID, escrow.Receiver, escrow.TxnID)
+	}
+	asset.Owner = escrow.Receiver // new owner is receiver
+	asset.LastEscrow = escrow.TxnID
+	if err := s.putAsset(ctx, asset); err != nil {
+		return err
+	}
+	return moveLotUnits(ctx, asset.ChipID, escrow.Sender, escrow.Receiver, escrow.TxnID)
+}
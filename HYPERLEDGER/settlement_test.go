@@ -0,0 +1,78 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import "testing"
+
+// escrowFixture stores lot "lot1" and returns a single-lot escrow of it from
+// Org1 to Org2 carried by Org3, delivered, funded with 100 and staked with 50,
+// with both parties' reveals matching the lot.
+func escrowFixture(t *testing.T, s *SmartContract, ctx *testContext) *EscrowContract {
+	hash := hashVerifyValue("salt", "value")
+	lot := &Asset{ChipID: "lot1", DocType: "asset", Owner: "Org1MSP", Quantity: 10, VerifyHash: hash, VerifySalt: "salt"}
+	if err := s.putAsset(ctx, lot); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+	return &EscrowContract{
+		AssetID:        "lot1",
+		Delivery:       "Org3MSP",
+		DeliveryStake:  50,
+		DocType:        "escrow",
+		EscrowAmount:   100,
+		LockedAmount:   100,
+		LockedStake:    50,
+		Receiver:       "Org2MSP",
+		ReceiverReveal: hash,
+		Sender:         "Org1MSP",
+		SenderReveal:   hash,
+		Status:         StatusDelivered,
+		TxnID:          "escrow1",
+	}
+}
+
+func TestSettleVerificationWithSharedParties(t *testing.T) {
+	s := &SmartContract{}
+	ctx := newTestContext("Org2MSP")
+	escrow := escrowFixture(t, s, ctx)
+	escrow.Delivery = escrow.Sender
+
+	if err := s.settleVerification(ctx, escrow); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+
+	requireBalance(t, s, ctx, "Org1MSP", 150)
+	reputation, err := readReputation(ctx, "Org1MSP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reputation.CompletedDeliveries != 1 {
+		t.Errorf("completed deliveries: got %d, want 1", reputation.CompletedDeliveries)
+	}
+}
+
+func TestRuledSplitWithSharedParties(t *testing.T) {
+	s := &SmartContract{}
+	ctx := newTestContext("Org2MSP")
+	escrow := escrowFixture(t, s, ctx)
+	escrow.Delivery = escrow.Sender
+	escrow.Status = StatusDisputed
+
+	if err := s.settleOutcome(ctx, escrow, OutcomeRuledSplit); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+
+	requireBalance(t, s, ctx, "Org1MSP", 100)
+	requireBalance(t, s, ctx, "Org2MSP", 50)
+	lot, err := s.ReadAsset(ctx, "lot1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lot.Owner != "Org2MSP" {
+		t.Errorf("owner of lot1: got %s, want Org2MSP", lot.Owner)
+	}
+}
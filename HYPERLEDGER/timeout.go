@@ -0,0 +1,110 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Stage durations in seconds, counted cumulatively from the Init timestamp.
+const (
+	fundWindow    = 2 * 24 * 60 * 60  // B calls StartDelivery
+	shipWindow    = 3 * 24 * 60 * 60  // A calls InitiateDelivery
+	deliverWindow = 14 * 24 * 60 * 60 // D calls ConfirmDelivery
+	verifyWindow  = 7 * 24 * 60 * 60  // A and B commit their verification values
+)
+
+// Deadlines are unix seconds after which the stage's party has missed its step.
+type Deadlines struct {
+	DeliverBy int64 `json:"deliverBy"`
+	FundBy    int64 `json:"fundBy"`
+	ShipBy    int64 `json:"shipBy"`
+	VerifyBy  int64 `json:"verifyBy"`
+}
+
+// newDeadlines lays the stage deadlines out from the Init timestamp.
+func newDeadlines(start int64) Deadlines {
+	d := Deadlines{FundBy: start + fundWindow}
+	d.ShipBy = d.FundBy + shipWindow
+	d.DeliverBy = d.ShipBy + deliverWindow
+	d.VerifyBy = d.DeliverBy + verifyWindow
+	return d
+}
+
+// ClaimTimeout settles an escrow whose current stage deadline has passed,
+// according to who missed their step. Any party of the escrow can call it. [invoke]
+//
+//	DRAFTED past fundBy              -> EXPIRED, nothing was locked
+//	FUNDED past shipBy               -> EXPIRED, escrow back to B
+//	HANDED_TO_CARRIER past deliverBy -> EXPIRED, escrow back to B, D's stake to A
+//	DELIVERED past verifyBy          -> reveal phase settles as in VerifyProduct; without
+//	                                    one, a missing A commitment is A's fault, otherwise
+//	                                    B's silence accepts the goods
+//	DISPUTED past the dispute's ruleBy -> RESOLVED as RULED_SPLIT, nobody ruled in time
+func (s *SmartContract) ClaimTimeout(ctx contractapi.TransactionContextInterface, txn string) error {
+	escrow, err := s.readEscrow(ctx, txn)
+	if err != nil {
+		return err
+	}
+	x, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+	if x != escrow.Sender && x != escrow.Receiver && x != escrow.Delivery {
+		return fmt.Errorf("only a party of escrow %s can claim a timeout", txn)
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	var deadline int64
+	var outcome SettlementOutcome
+	switch escrow.Status {
+	case StatusDrafted:
+		deadline, outcome = escrow.Deadlines.FundBy, OutcomeFundingTimeout
+	case StatusFunded:
+		deadline, outcome = escrow.Deadlines.ShipBy, OutcomeShippingTimeout
+	case StatusHandedToCarrier:
+		deadline, outcome = escrow.Deadlines.DeliverBy, OutcomeDeliveryTimeout
+	case StatusDelivered:
+		deadline = escrow.Deadlines.VerifyBy
+		if escrow.RevealDeadline != 0 {
+			if now <= escrow.RevealDeadline {
+				return fmt.Errorf("escrow %s is in its reveal phase until %d", txn, escrow.RevealDeadline)
+			}
+			return s.settleVerification(ctx, escrow)
+		}
+		if escrow.SenderCommitment == "" && escrow.SenderReveal == "" {
+			outcome = OutcomeSenderAtFault
+		} else {
+			outcome = OutcomeVerifyTimeout
+		}
+	case StatusDisputed:
+		dispute, err := readDispute(ctx, txn)
+		if err != nil {
+			return err
+		}
+		deadline = dispute.RuleBy
+		if deadline != 0 && now > deadline {
+			if outcome, err = lapseDispute(ctx, dispute); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("escrow %s is %s and has no pending deadline", txn, escrow.Status)
+	}
+
+	if deadline == 0 {
+		// escrows migrated from before deadlines existed
+		return fmt.Errorf("escrow %s has no deadlines", txn)
+	}
+	if now <= deadline {
+		return fmt.Errorf("escrow %s has not timed out, %s deadline is %d", txn, escrow.Status, deadline)
+	}
+	return s.settleOutcome(ctx, escrow, outcome)
+}
@@ -0,0 +1,103 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import "testing"
+
+func TestClaimTimeoutPerStage(t *testing.T) {
+	tests := []struct {
+		name             string
+		status           EscrowStatus
+		locked, stake    uint64
+		senderCommitment string
+		outcome          SettlementOutcome
+		receiverGets     uint64
+		senderGets       uint64
+		carrierGets      uint64
+	}{
+		{"funding", StatusDrafted, 0, 0, "", OutcomeFundingTimeout, 0, 0, 0},
+		{"shipping", StatusFunded, 100, 0, "", OutcomeShippingTimeout, 100, 0, 0},
+		{"delivery", StatusHandedToCarrier, 100, 50, "", OutcomeDeliveryTimeout, 100, 50, 0},
+		{"verification, sender silent", StatusDelivered, 100, 50, "", OutcomeSenderAtFault, 0, 0, 0},
+		{"verification, receiver silent", StatusDelivered, 100, 50, "commitment", OutcomeVerifyTimeout, 0, 100, 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &SmartContract{}
+			ctx := newTestContext("Org2MSP")
+			escrow := escrowFixture(t, s, ctx)
+			escrow.Deadlines = Deadlines{FundBy: 10, ShipBy: 20, DeliverBy: 30, VerifyBy: 40}
+			escrow.LockedAmount, escrow.LockedStake = tt.locked, tt.stake
+			escrow.ReceiverReveal, escrow.SenderReveal = "", ""
+			escrow.SenderCommitment = tt.senderCommitment
+			escrow.Status = tt.status
+			if err := s.putEscrow(ctx, escrow); err != nil {
+				t.Fatal(err)
+			}
+			ctx.stub.commit()
+
+			ctx.stub.now = 5
+			if err := s.ClaimTimeout(ctx, "escrow1"); err == nil {
+				t.Fatal("claimed a timeout before any deadline")
+			}
+			ctx.stub.now = 1000
+			if err := s.ClaimTimeout(ctx, "escrow1"); err != nil {
+				t.Fatal(err)
+			}
+			ctx.stub.commit()
+
+			settled, err := s.ReadEscrow(ctx, "escrow1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if settled.Outcome != tt.outcome {
+				t.Errorf("outcome: got %s, want %s", settled.Outcome, tt.outcome)
+			}
+			requireBalance(t, s, ctx, "Org2MSP", tt.receiverGets)
+			requireBalance(t, s, ctx, "Org1MSP", tt.senderGets)
+			requireBalance(t, s, ctx, "Org3MSP", tt.carrierGets)
+		})
+	}
+}
+
+func TestDeliveryTimeoutForfeitsTheStakeTakenAtHandover(t *testing.T) {
+	s := &SmartContract{}
+	ctx := newTestContext("Org1MSP")
+	addStakeholders(t, ctx, "Org1MSP", "Org2MSP", "Org3MSP")
+	escrow := escrowFixture(t, s, ctx)
+	escrow.Deadlines = Deadlines{FundBy: 10, ShipBy: 20, DeliverBy: 30, VerifyBy: 40}
+	escrow.LockedStake = 0
+	escrow.Status = StatusFunded
+	if err := s.putEscrow(ctx, escrow); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.putBalance(ctx, "Org3MSP", 80); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+
+	if err := s.InitiateDelivery(ctx, "escrow1", true); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+	requireBalance(t, s, ctx, "Org3MSP", 30)
+
+	ctx.stub.now = 1000
+	if err := s.ClaimTimeout(ctx, "escrow1"); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+
+	requireBalance(t, s, ctx, "Org1MSP", 50)
+	requireBalance(t, s, ctx, "Org2MSP", 100)
+	requireBalance(t, s, ctx, "Org3MSP", 30)
+	reputation, err := readReputation(ctx, "Org3MSP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reputation.MissedDeliveries != 1 || reputation.StakesForfeited != 1 || reputation.penalty() != 1 {
+		t.Errorf("carrier reputation: got %+v", reputation)
+	}
+}
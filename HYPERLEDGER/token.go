@@ -0,0 +1,219 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Settlement token balances are held per MSP ID under the "balance" composite key.
+// Funds locked by an escrow are debited from the payer and recorded on the
+// EscrowContract itself (LockedAmount, LockedStake) until it settles.
+
+func balanceKey(ctx contractapi.TransactionContextInterface, account string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(balanceObjectType, []string{account})
+}
+
+// BalanceOf returns the settlement token balance of an MSP ID. [query]
+func (s *SmartContract) BalanceOf(ctx contractapi.TransactionContextInterface, account string) (uint64, error) {
+	key, err := balanceKey(ctx, account)
+	if err != nil {
+		return 0, err
+	}
+	balanceBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if balanceBytes == nil {
+		return 0, nil
+	}
+
+	balance, err := strconv.ParseUint(string(balanceBytes), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("corrupt balance for %s: %v", account, err)
+	}
+	return balance, nil
+}
+
+func (s *SmartContract) putBalance(ctx contractapi.TransactionContextInterface, account string, balance uint64) error {
+	key, err := balanceKey(ctx, account)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, []byte(strconv.FormatUint(balance, 10)))
+}
+
+// credit adds amount to the account balance.
+func (s *SmartContract) credit(ctx contractapi.TransactionContextInterface, account string, amount uint64) error {
+	if amount == 0 {
+		return nil
+	}
+	balance, err := s.BalanceOf(ctx, account)
+	if err != nil {
+		return err
+	}
+	if balance > math.MaxUint64-amount {
+		return fmt.Errorf("balance of %s would overflow", account)
+	}
+	return s.putBalance(ctx, account, balance+amount)
+}
+
+// debit removes amount from the account balance.
+func (s *SmartContract) debit(ctx contractapi.TransactionContextInterface, account string, amount uint64) error {
+	if amount == 0 {
+		return nil
+	}
+	balance, err := s.BalanceOf(ctx, account)
+	if err != nil {
+		return err
+	}
+	if balance < amount {
+		return fmt.Errorf("%s has insufficient funds: balance %d, needs %d", account, balance, amount)
+	}
+	return s.putBalance(ctx, account, balance-amount)
+}
+
+// Mint creates new settlement tokens for an account. Admin org only. [invoke]
+func (s *SmartContract) Mint(ctx contractapi.TransactionContextInterface, account string, amount uint64) error {
+	if _, err := requireAdmin(ctx); err != nil {
+		return err
+	}
+	if amount == 0 {
+		return fmt.Errorf("mint amount must be positive")
+	}
+
+	return s.credit(ctx, account, amount)
+}
+
+// Transfer moves settlement tokens from the caller's org to recipient. [invoke]
+func (s *SmartContract) Transfer(ctx contractapi.TransactionContextInterface, recipient string, amount uint64) error {
+	x, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+	if x == recipient {
+		return fmt.Errorf("cannot transfer to yourself")
+	}
+	if amount == 0 {
+		return fmt.Errorf("transfer amount must be positive")
+	}
+
+	if err := s.debit(ctx, x, amount); err != nil {
+		return err
+	}
+	return s.credit(ctx, recipient, amount)
+}
+
+// Fabric reads do not see the transaction's own writes, so an account paid or
+// charged twice in one transaction would keep only the last write. Escrow steps
+// therefore book their token movements on the escrow and recordEscrow writes the
+// net change of every account once.
+
+// tokenMoves holds the token movements booked on an escrow in this transaction.
+type tokenMoves struct {
+	credits map[string]uint64
+	debits  map[string]uint64
+}
+
+// pay books amount to be credited to account when the escrow is recorded.
+func (e *EscrowContract) pay(account string, amount uint64) error {
+	if amount == 0 {
+		return nil
+	}
+	if e.moves.credits == nil {
+		e.moves.credits = map[string]uint64{}
+	}
+	if e.moves.credits[account] > math.MaxUint64-amount {
+		return fmt.Errorf("payout to %s would overflow", account)
+	}
+	e.moves.credits[account] += amount
+	return nil
+}
+
+// charge books amount to be debited from account when the escrow is recorded.
+func (e *EscrowContract) charge(account string, amount uint64) error {
+	if amount == 0 {
+		return nil
+	}
+	if e.moves.debits == nil {
+		e.moves.debits = map[string]uint64{}
+	}
+	if e.moves.debits[account] > math.MaxUint64-amount {
+		return fmt.Errorf("charge to %s would overflow", account)
+	}
+	e.moves.debits[account] += amount
+	return nil
+}
+
+// settleTokenMoves writes the booked movements, one balance update per account
+// in account order, and clears them.
+func (s *SmartContract) settleTokenMoves(ctx contractapi.TransactionContextInterface, escrow *EscrowContract) error {
+	var accounts []string
+	for account := range escrow.moves.credits {
+		accounts = append(accounts, account)
+	}
+	for account := range escrow.moves.debits {
+		if _, ok := escrow.moves.credits[account]; !ok {
+			accounts = append(accounts, account)
+		}
+	}
+	sort.Strings(accounts)
+
+	for _, account := range accounts {
+		credit, debit := escrow.moves.credits[account], escrow.moves.debits[account]
+		balance, err := s.BalanceOf(ctx, account)
+		if err != nil {
+			return err
+		}
+		if balance > math.MaxUint64-credit {
+			return fmt.Errorf("balance of %s would overflow", account)
+		}
+		if balance+credit < debit {
+			return fmt.Errorf("%s has insufficient funds: balance %d, needs %d", account, balance, debit-credit)
+		}
+		if err := s.putBalance(ctx, account, balance+credit-debit); err != nil {
+			return err
+		}
+	}
+	escrow.moves = tokenMoves{}
+	return nil
+}
+
+// lockEscrowAmount takes the receiver's payment into the escrow.
+func (e *EscrowContract) lockEscrowAmount() error {
+	if err := e.charge(e.Receiver, e.EscrowAmount); err != nil {
+		return err
+	}
+	e.LockedAmount = e.EscrowAmount
+	return nil
+}
+
+// lockDeliveryStake takes the carrier's stake into the escrow.
+func (e *EscrowContract) lockDeliveryStake() error {
+	if err := e.charge(e.Delivery, e.DeliveryStake); err != nil {
+		return err
+	}
+	e.LockedStake = e.DeliveryStake
+	return nil
+}
+
+// releaseFunds pays out everything the escrow holds: the locked escrow amount
+// to amountTo and the locked delivery stake to stakeTo.
+func (e *EscrowContract) releaseFunds(amountTo, stakeTo string) error {
+	if err := e.pay(amountTo, e.LockedAmount); err != nil {
+		return err
+	}
+	e.LockedAmount = 0
+	if err := e.pay(stakeTo, e.LockedStake); err != nil {
+		return err
+	}
+	e.LockedStake = 0
+	return nil
+}
@@ -0,0 +1,466 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// An Asset is a lot of Quantity chips. The manufacturer can register each
+// chip's serial under its lot, with its own verification hash, so single
+// units can be traced and authenticated. Units are held by the lot's owner and
+// follow the lot. TransferUnits and escrows that name serials carve the units
+// out into a child lot for the new owner, so a lot's Quantity only counts the
+// chips its owner still holds.
+
+// transientUnitValuesKey is the transient map entry carrying a JSON object of
+// serial -> verification value.
+const transientUnitValuesKey = "unitValues"
+
+type Unit struct {
+	CompromisedBy string `json:"compromisedBy"` // confirmed counterfeit report, "" if none
+	DocType       string `json:"docType"`       // always "unit"
+	LastEscrow    string `json:"lastEscrow"`    // escrow that caused the latest ownership change
+	LotID         string `json:"lotID"`         // chip ID of the parent Asset
+	Owner         string `json:"owner"`
+	RecallID      string `json:"recallID"` // recall that flagged this unit on its own, "" if none
+	Serial        string `json:"serial"`   // UNIQUE across lots
+	VerifyHash    string `json:"verifyHash"`
+	VerifySalt    string `json:"verifySalt"`
+}
+
+func readUnit(ctx contractapi.TransactionContextInterface, serial string) (*Unit, error) {
+	key, err := unitKey(ctx, serial)
+	if err != nil {
+		return nil, err
+	}
+	unitJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if unitJSON == nil {
+		return nil, fmt.Errorf("the unit %s does not exist", serial)
+	}
+
+	var unit Unit
+	err = json.Unmarshal(unitJSON, &unit)
+	if err != nil {
+		return nil, err
+	}
+	return &unit, nil
+}
+
+// putUnit writes the unit and its lot index entry.
+func putUnit(ctx contractapi.TransactionContextInterface, unit *Unit) error {
+	key, err := unitKey(ctx, unit.Serial)
+	if err != nil {
+		return err
+	}
+	indexKey, err := ctx.GetStub().CreateCompositeKey(lotIndex, []string{unit.LotID, unit.Serial})
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutState(indexKey, []byte{0x00}); err != nil {
+		return err
+	}
+
+	unit.DocType = unitObjectType
+	unitJSON, err := json.Marshal(unit)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, unitJSON)
+}
+
+// lotUnits returns every unit registered under a lot.
+func lotUnits(ctx contractapi.TransactionContextInterface, lotID string) ([]*Unit, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(lotIndex, []string{lotID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var units []*Unit
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		unit, err := readUnit(ctx, attributes[1])
+		if err != nil {
+			return nil, err
+		}
+		units = append(units, unit)
+	}
+	return units, nil
+}
+
+// relotUnit moves a unit under another lot, for splits and merges.
+func relotUnit(ctx contractapi.TransactionContextInterface, unit *Unit, lotID string) error {
+	staleKey, err := ctx.GetStub().CreateCompositeKey(lotIndex, []string{unit.LotID, unit.Serial})
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().DelState(staleKey); err != nil {
+		return err
+	}
+	unit.LotID = lotID
+	return putUnit(ctx, unit)
+}
+
+// moveLotUnits hands the units still held with a lot to the lot's new owner.
+func moveLotUnits(ctx contractapi.TransactionContextInterface, lotID, from, to, escrowID string) error {
+	units, err := lotUnits(ctx, lotID)
+	if err != nil {
+		return err
+	}
+	for _, unit := range units {
+		if unit.Owner != from {
+			continue
+		}
+		unit.Owner = to
+		unit.LastEscrow = escrowID
+		if err := putUnit(ctx, unit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// carveUnits hands units of lot to newOwner in a child lot childID, taking
+// their count off the lot. Units that make up the whole lot take the lot along.
+func (s *SmartContract) carveUnits(ctx contractapi.TransactionContextInterface, lot *Asset, units []*Unit, childID, newOwner, escrowID string) error {
+	quantity := uint64(len(units))
+	lotID := childID
+	if quantity >= lot.Quantity {
+		lotID = lot.ChipID
+		lot.Owner = newOwner
+		lot.LastEscrow = escrowID
+		if err := s.putAsset(ctx, lot); err != nil {
+			return err
+		}
+	} else {
+		exists, err := s.AssetExists(ctx, childID)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("the asset %s already exists", childID)
+		}
+		child := childLot(lot, childID, quantity)
+		child.Owner = newOwner
+		child.LastEscrow = escrowID
+		lot.Quantity -= quantity
+		if err := s.putAsset(ctx, lot); err != nil {
+			return err
+		}
+		if err := s.putAsset(ctx, &child); err != nil {
+			return err
+		}
+	}
+
+	for _, unit := range units {
+		unit.Owner = newOwner
+		unit.LastEscrow = escrowID
+		if err := relotUnit(ctx, unit, lotID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ownedUnits loads the named serials of a lot and checks that owner holds
+// each of them once.
+func ownedUnits(ctx contractapi.TransactionContextInterface, lotID string, serials []string, owner string) ([]*Unit, error) {
+	seen := map[string]bool{}
+	var units []*Unit
+	for _, serial := range serials {
+		if seen[serial] {
+			return nil, fmt.Errorf("serial %s is listed twice", serial)
+		}
+		seen[serial] = true
+		unit, err := readUnit(ctx, serial)
+		if err != nil {
+			return nil, err
+		}
+		if unit.LotID != lotID {
+			return nil, fmt.Errorf("unit %s belongs to lot %s, not %s", serial, unit.LotID, lotID)
+		}
+		if unit.Owner != owner {
+			return nil, fmt.Errorf("unit %s is not owned by %s", serial, owner)
+		}
+		if unit.RecallID != "" {
+			return nil, fmt.Errorf("unit %s is recalled (%s)", serial, unit.RecallID)
+		}
+		if unit.CompromisedBy != "" {
+			return nil, fmt.Errorf("unit %s is a confirmed counterfeit (%s)", serial, unit.CompromisedBy)
+		}
+		units = append(units, unit)
+	}
+	return units, nil
+}
+
+// RegisterUnits records chip serials under a lot the caller minted and still
+// holds. Each serial's verification value comes from the transient map entry
+// unitValues, a JSON object of serial -> value; only salted hashes are made
+// public. A lot never holds more units than its Quantity. [invoke]
+func (s *SmartContract) RegisterUnits(ctx contractapi.TransactionContextInterface, lotID string, serials []string) error {
+	asset, err := s.ReadAsset(ctx, lotID)
+	if err != nil {
+		return err
+	}
+	if _, err := requireCaller(ctx, asset.Manufacturer, "the lot's manufacturer"); err != nil {
+		return err
+	}
+	if err := requireActiveManufacturer(ctx, asset.Manufacturer); err != nil {
+		return err
+	}
+	if asset.Owner != asset.Manufacturer {
+		return fmt.Errorf("lot %s has already left its manufacturer", lotID)
+	}
+	if err := requireTradable(asset); err != nil {
+		return err
+	}
+	if len(serials) == 0 {
+		return fmt.Errorf("no serials to register")
+	}
+	listed := map[string]bool{}
+	for _, serial := range serials {
+		if listed[serial] {
+			return fmt.Errorf("serial %s is listed twice", serial)
+		}
+		listed[serial] = true
+	}
+	registered, err := lotUnits(ctx, lotID)
+	if err != nil {
+		return err
+	}
+	if uint64(len(registered)+len(serials)) > asset.Quantity {
+		return fmt.Errorf("lot %s holds %d units, %d already registered", lotID, asset.Quantity, len(registered))
+	}
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("failed to read transient map: %v", err)
+	}
+	valuesJSON, ok := transientMap[transientUnitValuesKey]
+	if !ok || len(valuesJSON) == 0 {
+		return fmt.Errorf("%s must be supplied in the transient map", transientUnitValuesKey)
+	}
+	var values map[string]string
+	if err := json.Unmarshal(valuesJSON, &values); err != nil {
+		return fmt.Errorf("%s must be a JSON object of serial to value: %v", transientUnitValuesKey, err)
+	}
+
+	salt := ctx.GetStub().GetTxID()
+	units := []*Unit{}
+	for _, serial := range serials {
+		value := values[serial]
+		if value == "" {
+			return fmt.Errorf("no verification value for serial %s", serial)
+		}
+		key, err := unitKey(ctx, serial)
+		if err != nil {
+			return err
+		}
+		existing, err := ctx.GetStub().GetState(key)
+		if err != nil {
+			return fmt.Errorf("failed to read from world state: %v", err)
+		}
+		if existing != nil {
+			return fmt.Errorf("the unit %s already exists", serial)
+		}
+
+		unit := &Unit{
+			LotID:      lotID,
+			Owner:      asset.Owner,
+			Serial:     serial,
+			VerifyHash: hashVerifyValue(salt, value),
+			VerifySalt: salt,
+		}
+		if err := putUnit(ctx, unit); err != nil {
+			return err
+		}
+		if err := putUnitVerifySecret(ctx, asset.Manufacturer, lotID, serial, salt, value); err != nil {
+			return err
+		}
+		units = append(units, unit)
+	}
+
+	return emitEvent(ctx, EventUnitsRegistered, lotID, nil, units)
+}
+
+// TransferUnits hands named serials of a lot to another active stakeholder, in
+// a new lot lotID-<txID> unless they are the whole lot. Only the holder of
+// every listed unit can move them. [invoke]
+func (s *SmartContract) TransferUnits(ctx contractapi.TransactionContextInterface, lotID string, serials []string, newOwner string) error {
+	x, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+	if err := requireActiveStakeholder(ctx, newOwner); err != nil {
+		return err
+	}
+	if len(serials) == 0 {
+		return fmt.Errorf("no serials to transfer")
+	}
+	lot, err := s.ReadAsset(ctx, lotID)
+	if err != nil {
+		return err
+	}
+	if err := requireTradable(lot); err != nil {
+		return err
+	}
+	if err := requireUnlocked(ctx, lotID, serials); err != nil {
+		return err
+	}
+	units, err := ownedUnits(ctx, lotID, serials, x)
+	if err != nil {
+		return err
+	}
+
+	old := make([]Unit, len(units))
+	for i, unit := range units {
+		old[i] = *unit
+	}
+	// direct transfer, no escrow involved
+	if err := s.carveUnits(ctx, lot, units, lotID+"-"+ctx.GetStub().GetTxID(), newOwner, ""); err != nil {
+		return err
+	}
+	return emitEvent(ctx, EventUnitsTransferred, lotID, old, units)
+}
+
+// ReadUnit returns the unit registered under serial. [query]
+func (s *SmartContract) ReadUnit(ctx contractapi.TransactionContextInterface, serial string) (*Unit, error) {
+	return readUnit(ctx, serial)
+}
+
+// GetLotUnits returns every unit registered under a lot. [query]
+func (s *SmartContract) GetLotUnits(ctx contractapi.TransactionContextInterface, lotID string) ([]*Unit, error) {
+	if _, err := s.ReadAsset(ctx, lotID); err != nil {
+		return nil, err
+	}
+	return lotUnits(ctx, lotID)
+}
+
+// verifyDigest folds per-unit hashes, in escrow order, into one value that
+// the escrow's reveals are compared against.
+func verifyDigest(hashes []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(hashes, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// expectedVerifyHash returns what a correct reveal for the escrow hashes to:
+// the lot's hash, or the digest of every named unit's own hash.
+func (s *SmartContract) expectedVerifyHash(ctx contractapi.TransactionContextInterface, escrow *EscrowContract) (string, error) {
+	if len(escrow.Serials) == 0 {
+		asset, err := s.ReadAsset(ctx, escrow.AssetID)
+		if err != nil {
+			return "", err
+		}
+		return asset.VerifyHash, nil
+	}
+	var hashes []string
+	for _, serial := range escrow.Serials {
+		unit, err := readUnit(ctx, serial)
+		if err != nil {
+			return "", err
+		}
+		hashes = append(hashes, unit.VerifyHash)
+	}
+	return verifyDigest(hashes), nil
+}
+
+// revealedVerifyHash hashes a revealed value the way expectedVerifyHash does.
+// For an escrow naming serials the value is a JSON object of serial -> value,
+// and every unit is checked against its own salt.
+func (s *SmartContract) revealedVerifyHash(ctx contractapi.TransactionContextInterface, escrow *EscrowContract, value string) (string, error) {
+	if len(escrow.Lines) > 0 {
+		hashes, err := s.orderLineHashes(ctx, escrow, value)
+		if err != nil {
+			return "", err
+		}
+		return verifyDigest(hashes), nil
+	}
+	if len(escrow.Serials) == 0 {
+		asset, err := s.ReadAsset(ctx, escrow.AssetID)
+		if err != nil {
+			return "", err
+		}
+		return hashVerifyValue(asset.VerifySalt, value), nil
+	}
+	hashes, err := s.unitHashes(ctx, escrow, value)
+	if err != nil {
+		return "", err
+	}
+	return verifyDigest(hashes), nil
+}
+
+// unitHashes hashes a revealed JSON object of serial -> value with each named
+// unit's salt, in escrow order.
+func (s *SmartContract) unitHashes(ctx contractapi.TransactionContextInterface, escrow *EscrowContract, value string) ([]string, error) {
+	var values map[string]string
+	if err := json.Unmarshal([]byte(value), &values); err != nil {
+		return nil, fmt.Errorf("escrow %s names serials, the revealed value must be a JSON object of serial to value: %v", escrow.TxnID, err)
+	}
+	var hashes []string
+	for _, serial := range escrow.Serials {
+		unit, err := readUnit(ctx, serial)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hashVerifyValue(unit.VerifySalt, values[serial]))
+	}
+	return hashes, nil
+}
+
+// revealUnits keeps the revealing party's hash of every named unit so that
+// settlement can tell which units failed.
+func (s *SmartContract) revealUnits(ctx contractapi.TransactionContextInterface, escrow *EscrowContract, sender bool, value string) error {
+	hashes, err := s.unitHashes(ctx, escrow, value)
+	if err != nil {
+		return err
+	}
+	if sender {
+		escrow.SenderUnitReveals = hashes
+	} else {
+		escrow.ReceiverUnitReveals = hashes
+	}
+	return nil
+}
+
+// unitFindings compares every named unit on its own. A unit without a reveal
+// from a party counts as a mismatch, like the escrow as a whole.
+func (s *SmartContract) unitFindings(ctx contractapi.TransactionContextInterface, escrow *EscrowContract) (map[string]SettlementOutcome, error) {
+	findings := map[string]SettlementOutcome{}
+	for i, serial := range escrow.Serials {
+		unit, err := readUnit(ctx, serial)
+		if err != nil {
+			return nil, err
+		}
+		var aValue, bValue string
+		if i < len(escrow.SenderUnitReveals) {
+			aValue = escrow.SenderUnitReveals[i]
+		}
+		if i < len(escrow.ReceiverUnitReveals) {
+			bValue = escrow.ReceiverUnitReveals[i]
+		}
+		findings[serial] = revealFinding(unit.VerifyHash, aValue, bValue)
+		if unit.CompromisedBy != "" {
+			findings[serial] = OutcomeSenderAtFault
+		}
+	}
+	return findings, nil
+}
@@ -0,0 +1,54 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package chaincode
+
+import "testing"
+
+func TestCarveUnitsTakesThemOffTheLot(t *testing.T) {
+	s := &SmartContract{}
+	ctx := &testContext{stub: newLedgerStub(), identity: &mspIdentity{mspID: "Org1MSP"}}
+	lot := &Asset{ChipID: "lotA", DocType: "asset", Owner: "Org1MSP", Quantity: 10}
+	if err := s.putAsset(ctx, lot); err != nil {
+		t.Fatal(err)
+	}
+	var units []*Unit
+	for _, serial := range []string{"s1", "s2", "s3"} {
+		unit := &Unit{LotID: "lotA", Owner: "Org1MSP", Serial: serial}
+		if err := putUnit(ctx, unit); err != nil {
+			t.Fatal(err)
+		}
+		units = append(units, unit)
+	}
+	ctx.stub.commit()
+
+	if err := s.carveUnits(ctx, lot, units[:2], "lotA-e1", "Org2MSP", "e1"); err != nil {
+		t.Fatal(err)
+	}
+	ctx.stub.commit()
+
+	for id, want := range map[string]uint64{"lotA": 8, "lotA-e1": 2} {
+		asset, err := s.ReadAsset(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if asset.Quantity != want {
+			t.Errorf("quantity of %s: got %d, want %d", id, asset.Quantity, want)
+		}
+	}
+	carved, err := lotUnits(ctx, "lotA-e1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(carved) != 2 || carved[0].Owner != "Org2MSP" {
+		t.Errorf("carved units: got %+v", carved)
+	}
+	left, err := lotUnits(ctx, "lotA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 1 || left[0].Serial != "s3" {
+		t.Errorf("units left on lotA: got %+v", left)
+	}
+}